@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math"
+)
+
+// asymmetric (directed) tsp support
+// moves here never reverse a segment so they stay valid when a->b != b->a
+
+// dense cost table for the stops, rows are from
+func (ps *pnts) costs() [][]float64 {
+	c := make([][]float64, len(*ps))
+	for i, a := range *ps {
+		c[i] = make([]float64, len(*ps))
+		for j, b := range *ps {
+			if i != j {
+				c[i][j] = cost(a, b)
+			}
+		}
+	}
+	return c
+}
+
+// true if the active costs differ by direction for any pair of stops
+func (ps *pnts) isAsym() bool {
+	const eps = 1e-9
+	if mat == nil {
+		return false
+	}
+	for i, a := range *ps {
+		for _, b := range (*ps)[i+1:] {
+			if math.Abs(cost(a, b)-cost(b, a)) > eps {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// directed length of the tour ord over cost table c
+func ordLen(c [][]float64, ord []int) float64 {
+	var sum float64
+	for i := range ord {
+		sum += c[ord[i]][ord[(i+1)%len(ord)]]
+	}
+	return sum
+}
+
+// reorder pnts by index order
+func (ps *pnts) byOrd(ord []int) pnts {
+	out := make(pnts, len(ord))
+	for i, v := range ord {
+		out[i] = (*ps)[v]
+	}
+	return out
+}
+
+// directed local search from the given order
+// or-opt (move 1-3 stops forward or back) then segment exchange 3-opt, until neither improves
+// 3-opt is only tried up to lim3 stops as it is O(n^3) a pass
+func (ps *pnts) atspOpt(lim3 int) pnts {
+	c := ps.costs()
+	ord := make([]int, len(*ps))
+	for i := range ord {
+		ord[i] = i
+	}
+
+	for {
+		ord = orOpt(c, ord)
+		if len(ord) > lim3 {
+			break
+		}
+		next, upd := or3opt(c, ord)
+		if !upd {
+			break
+		}
+		ord = next
+	}
+
+	return ps.byOrd(ord)
+}
+
+// or-opt, first improvement
+// https://en.wikipedia.org/wiki/Or-opt (segment insertion, orientation kept)
+func orOpt(c [][]float64, ord []int) []int {
+	const eps = 1e-9
+	n := len(ord)
+	if n < 5 {
+		return ord
+	}
+
+	for upd := true; upd; {
+		upd = false
+		for l := 1; l <= 3; l++ {
+			for i := 0; i+l <= n; i++ {
+				prev, next := ord[(i-1+n)%n], ord[(i+l)%n]
+				s0, sl := ord[i], ord[i+l-1]
+				gain := c[prev][s0] + c[sl][next] - c[prev][next]
+
+				for j := 0; j < n; j++ {
+					if j >= i-1 && j <= i+l-1 || (i == 0 && j == n-1) {
+						continue // edge touches the segment
+					}
+					a, b := ord[j], ord[(j+1)%n]
+					if c[a][s0]+c[sl][b]-c[a][b] < gain-eps {
+						ord = moveSeg(ord, i, l, j)
+						upd = true
+						break
+					}
+				}
+			}
+		}
+	}
+	return ord
+}
+
+// move ord[i:i+l] to sit after position j (index in the original ord)
+func moveSeg(ord []int, i, l, j int) []int {
+	seg := append([]int{}, ord[i:i+l]...)
+	out := make([]int, 0, len(ord))
+	for k, v := range ord {
+		if k >= i && k < i+l {
+			continue
+		}
+		out = append(out, v)
+		if k == j {
+			out = append(out, seg...)
+		}
+	}
+	return out
+}
+
+// 3-opt segment exchange, A B C -> A C B with no reversal
+// first improvement, false if no move found
+func or3opt(c [][]float64, ord []int) ([]int, bool) {
+	const eps = 1e-9
+	n := len(ord)
+	for i := 0; i < n-2; i++ {
+		for j := i + 1; j < n-1; j++ {
+			for k := j + 1; k < n; k++ {
+				a, a1 := ord[i], ord[i+1]
+				b, b1 := ord[j], ord[j+1]
+				d, d1 := ord[k], ord[(k+1)%n]
+				delta := c[a][b1] + c[d][a1] + c[b][d1] - c[a][a1] - c[b][b1] - c[d][d1]
+				if delta < -eps {
+					out := make([]int, 0, n)
+					out = append(out, ord[:i+1]...)
+					out = append(out, ord[j+1:k+1]...)
+					out = append(out, ord[i+1:j+1]...)
+					out = append(out, ord[k+1:]...)
+					return out, true
+				}
+			}
+		}
+	}
+	return ord, false
+}
+
+// largest tour heldKarp is run on, memory grows as n*2^n
+const maxHK = 16
+
+// exact directed tour by dynamic programming, O(n^2 2^n) ## don't use > maxHK nodes! ##
+// https://en.wikipedia.org/wiki/Held%E2%80%93Karp_algorithm
+func (ps *pnts) heldKarp() pnts {
+	n := len(*ps)
+	if n < 3 {
+		return append(pnts{}, *ps...)
+	}
+	c := ps.costs()
+
+	// stop 0 is the fixed start, sets are over stops 1..n-1
+	m := n - 1
+	full := 1<<uint(m) - 1
+	dp := make([][]float64, full+1)
+	par := make([][]int8, full+1)
+	for s := range dp {
+		dp[s] = make([]float64, m)
+		par[s] = make([]int8, m)
+		for j := range dp[s] {
+			dp[s][j] = math.Inf(1)
+		}
+	}
+	for j := 0; j < m; j++ {
+		dp[1<<uint(j)][j] = c[0][j+1]
+		par[1<<uint(j)][j] = -1
+	}
+
+	for s := 1; s <= full; s++ {
+		for j := 0; j < m; j++ {
+			if s&(1<<uint(j)) == 0 || math.IsInf(dp[s][j], 1) {
+				continue
+			}
+			for k := 0; k < m; k++ {
+				if s&(1<<uint(k)) != 0 {
+					continue
+				}
+				ns := s | 1<<uint(k)
+				if v := dp[s][j] + c[j+1][k+1]; v < dp[ns][k] {
+					dp[ns][k] = v
+					par[ns][k] = int8(j)
+				}
+			}
+		}
+	}
+
+	// close the loop back to 0
+	last, min := 0, math.Inf(1)
+	for j := 0; j < m; j++ {
+		if v := dp[full][j] + c[j+1][0]; v < min {
+			min, last = v, j
+		}
+	}
+
+	ord := make([]int, n)
+	for s, j, i := full, last, n-1; j != -1; i-- {
+		ord[i] = j + 1
+		pj := int(par[s][j])
+		s &^= 1 << uint(j)
+		j = pj
+	}
+	return ps.byOrd(ord)
+}
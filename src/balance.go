@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// limits for balanced clustering, zero values are unlimited
+type clsLim struct {
+	min    int               // min stops per cluster
+	max    int               // max stops per cluster
+	maxDem float64           // max summed demand per cluster
+	rad    float64           // max km from stop to its center
+	dem    map[point]float64 // demand of each stop
+}
+
+// true if limits are set
+func (l clsLim) active() bool {
+	return l.min > 0 || l.max > 0 || l.maxDem > 0 || l.rad > 0
+}
+
+// true if a cluster with cnt stops and load demand can take one more p at dist d
+func (l clsLim) fits(cnt int, load float64, p point, d float64) bool {
+	if l.rad > 0 && d > l.rad {
+		return false
+	}
+	if l.max > 0 && cnt+1 > l.max {
+		return false
+	}
+	if l.maxDem > 0 && load+l.dem[p] > l.maxDem {
+		return false
+	}
+	return true
+}
+
+// check that n points can be split into k clusters at all
+// with a radius set stops may go unassigned so only the limits themselves are checked
+func (l clsLim) check(ps pnts, k int) error {
+	if l.max > 0 && l.min > l.max {
+		return errors.New("min stops per cluster is greater than max")
+	}
+	if l.rad > 0 {
+		return nil
+	}
+
+	if l.max > 0 && len(ps) > k*l.max {
+		return fmt.Errorf("%d stops will not fit in %d clusters of max %d", len(ps), k, l.max)
+	}
+	if l.min*k > len(ps) {
+		return fmt.Errorf("%d stops can not fill %d clusters of min %d", len(ps), k, l.min)
+	}
+
+	if l.maxDem > 0 {
+		var tot float64
+		for _, p := range ps {
+			if l.dem[p] > l.maxDem {
+				return fmt.Errorf("stop %s demand %.2f is over max %.2f", p.lab, l.dem[p], l.maxDem)
+			}
+			tot += l.dem[p]
+		}
+		if tot > float64(k)*l.maxDem {
+			return fmt.Errorf("total demand %.2f will not fit in %d clusters of max %.2f", tot, k, l.maxDem)
+		}
+	}
+	return nil
+}
+
+// index of clusters breaking the limits
+func (l clsLim) over(c []cluster) []int {
+	out := []int{}
+	for i, v := range c {
+		if l.max > 0 && len(v.cls) > l.max || len(v.cls) < l.min {
+			out = append(out, i)
+			continue
+		}
+		if l.maxDem > 0 && l.load(v.cls) > l.maxDem {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// summed demand of pnts
+func (l clsLim) load(ps pnts) float64 {
+	var sum float64
+	for _, p := range ps {
+		sum += l.dem[p]
+	}
+	return sum
+}
+
+// balanced k-means
+// same as kmeans but each pass uses the constrained assignment
+func (ps *pnts) balKmeans(cls int, runs int, iter int, tol float64, lim clsLim) []cluster {
+	// every cluster needs a stop to keep its center
+	if lim.min < 1 {
+		lim.min = 1
+	}
+	asgn := func(c []cluster) []cluster {
+		res, _ := ps.balAsgn(c, lim)
+		return res
+	}
+	return ps.clsRuns(cls, runs, iter, tol, asgn)
+}
+
+// assign points to centers within limits
+// greedy by regret (points with most to lose go first), then min fill, then swap/move improvement
+// points out of radius of any center with room are returned unassigned
+// https://en.wikipedia.org/wiki/Generalized_assignment_problem
+func (ps *pnts) balAsgn(c []cluster, lim clsLim) ([]cluster, pnts) {
+	n, k := len(*ps), len(c)
+
+	// point to center distances
+	dist := make([][]float64, n)
+	for i, p := range *ps {
+		dist[i] = make([]float64, k)
+		for j, v := range c {
+			dist[i][j] = geoDist(p, v.ctr)
+		}
+	}
+
+	// centers ordered nearest first for each point
+	pref := make([][]int, n)
+	regret := make([]float64, n)
+	for i := range pref {
+		pref[i] = make([]int, k)
+		for j := range pref[i] {
+			pref[i][j] = j
+		}
+		d := dist[i]
+		sort.Slice(pref[i], func(a, b int) bool { return d[pref[i][a]] < d[pref[i][b]] })
+		if k > 1 {
+			regret[i] = d[pref[i][1]] - d[pref[i][0]]
+		}
+	}
+
+	ord := make([]int, n)
+	for i := range ord {
+		ord[i] = i
+	}
+	sort.SliceStable(ord, func(a, b int) bool { return regret[ord[a]] > regret[ord[b]] })
+
+	asg := make([]int, n)
+	cnt := make([]int, k)
+	load := make([]float64, k)
+	for _, i := range ord {
+		p := (*ps)[i]
+		best := -1
+		for _, j := range pref[i] {
+			if lim.fits(cnt[j], load[j], p, dist[i][j]) {
+				best = j
+				break
+			}
+		}
+
+		// nothing in reach, leave unassigned
+		if best == -1 && lim.rad > 0 {
+			asg[i] = -1
+			continue
+		}
+
+		// nothing fits, overflow into the least loaded cluster
+		if best == -1 {
+			best = 0
+			for j := range load {
+				if load[j] < load[best] || load[j] == load[best] && cnt[j] < cnt[best] {
+					best = j
+				}
+			}
+		}
+
+		asg[i] = best
+		cnt[best]++
+		load[best] += lim.dem[p]
+	}
+
+	// fill clusters under min with the cheapest points to move
+	for j := 0; j < k; j++ {
+		for cnt[j] < lim.min {
+			mv := -1
+			minCost := math.MaxFloat64
+			for i, p := range *ps {
+				from := asg[i]
+				if from == -1 || from == j || cnt[from] <= lim.min || !lim.fits(cnt[j], load[j], p, dist[i][j]) {
+					continue
+				}
+				if cost := dist[i][j] - dist[i][from]; cost < minCost {
+					minCost, mv = cost, i
+				}
+			}
+			if mv == -1 {
+				break
+			}
+			from := asg[mv]
+			cnt[from]--
+			load[from] -= lim.dem[(*ps)[mv]]
+			asg[mv] = j
+			cnt[j]++
+			load[j] += lim.dem[(*ps)[mv]]
+		}
+	}
+
+	// improvement passes, stop when nothing moves
+	const maxPass = 10
+	const eps = 1e-9
+	for pass := 0; pass < maxPass; pass++ {
+		upd := false
+		for a := 0; a < n; a++ {
+			pa := (*ps)[a]
+			ca := asg[a]
+			if ca == -1 {
+				continue
+			}
+
+			// single move to a nearer cluster with room
+			for _, j := range pref[a] {
+				if j == ca {
+					break // rest are farther
+				}
+				if cnt[ca] <= lim.min || !lim.fits(cnt[j], load[j], pa, dist[a][j]) {
+					continue
+				}
+				cnt[ca]--
+				load[ca] -= lim.dem[pa]
+				asg[a] = j
+				cnt[j]++
+				load[j] += lim.dem[pa]
+				ca = j
+				upd = true
+				break
+			}
+
+			// pairwise swap, counts unchanged
+			for b := a + 1; b < n; b++ {
+				cb := asg[b]
+				if ca == cb || cb == -1 {
+					continue
+				}
+				if lim.rad > 0 && (dist[a][cb] > lim.rad || dist[b][ca] > lim.rad) {
+					continue
+				}
+				delta := dist[a][cb] + dist[b][ca] - dist[a][ca] - dist[b][cb]
+				if delta > -eps {
+					continue
+				}
+				pb := (*ps)[b]
+				if lim.maxDem > 0 {
+					da, db := lim.dem[pa], lim.dem[pb]
+					if load[ca]-da+db > lim.maxDem || load[cb]-db+da > lim.maxDem {
+						continue
+					}
+				}
+				load[ca] += lim.dem[pb] - lim.dem[pa]
+				load[cb] += lim.dem[pa] - lim.dem[pb]
+				asg[a], asg[b] = cb, ca
+				ca = cb
+				upd = true
+			}
+		}
+		if !upd {
+			break
+		}
+	}
+
+	outCls := make([]cluster, k)
+	for j, v := range c {
+		outCls[j].ctr = v.ctr
+	}
+	var left pnts
+	for i, p := range *ps {
+		if asg[i] == -1 {
+			left = append(left, p)
+			continue
+		}
+		outCls[asg[i]].cls = append(outCls[asg[i]].cls, p)
+	}
+	return outCls, left
+}
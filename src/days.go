@@ -0,0 +1,64 @@
+package main
+
+// limits on one day of a split tour, 0 for none
+type dayLim struct {
+	stops int
+	dist  float64 // km or matrix unit, base legs included
+	hrs   float64 // drive plus service
+	svc   float64 // service minutes per stop
+	kph   float64 // drive speed for km legs
+}
+
+// length and hours of a day, from and back to base unless base is nil
+func (l dayLim) measure(d pnts, base *point) (float64, float64) {
+	var ln float64
+	for i := 1; i < len(d); i++ {
+		ln += cost(d[i-1], d[i])
+	}
+	if base != nil && len(d) > 0 {
+		ln += cost(*base, d[0]) + cost(d[len(d)-1], *base)
+	}
+
+	drive := ln / l.kph
+	if costUnit() == "min" {
+		drive = ln / 60
+	}
+	return ln, drive + l.svc*float64(len(d))/60
+}
+
+// true if d is within every limit
+func (l dayLim) fits(d pnts, base *point) bool {
+	const eps = 1e-9
+	ln, hrs := l.measure(d, base)
+	return (l.stops == 0 || len(d) <= l.stops) &&
+		(l.dist == 0 || ln <= l.dist+eps) &&
+		(l.hrs == 0 || hrs <= l.hrs+eps)
+}
+
+// cut the tour into consecutive days, each takes stops in order while it fits the limits
+// a stop that can't fit a day on its own gets a day anyway, returned in over
+func (ps *pnts) split(l dayLim, base *point) ([]pnts, pnts) {
+	var days []pnts
+	var over pnts
+	var cur pnts
+
+	for _, p := range *ps {
+		next := append(append(pnts{}, cur...), p)
+		if len(cur) == 0 || l.fits(next, base) {
+			cur = next
+			if len(cur) == 1 && !l.fits(cur, base) {
+				over = append(over, p)
+			}
+			continue
+		}
+		days = append(days, cur)
+		cur = pnts{p}
+		if !l.fits(cur, base) {
+			over = append(over, p)
+		}
+	}
+	if len(cur) > 0 {
+		days = append(days, cur)
+	}
+	return days, over
+}
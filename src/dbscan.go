@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sort"
+)
+
+// density based clustering
+// points with minPts neighbors inside eps (km) seed clusters, unreachable points are noise
+// https://en.wikipedia.org/wiki/DBSCAN
+func (ps *pnts) dbscan(eps float64, minPts int) ([]cluster, pnts) {
+
+	const (
+		unseen = -2
+		noise  = -1
+	)
+
+	nbrs := ps.region(eps)
+	lab := make([]int, len(*ps))
+	for i := range lab {
+		lab[i] = unseen
+	}
+
+	cls := 0
+	for i := range *ps {
+		if lab[i] != unseen {
+			continue
+		}
+		if len(nbrs[i])+1 < minPts { // count self
+			lab[i] = noise
+			continue
+		}
+
+		// grow cluster from core point
+		lab[i] = cls
+		q := append([]int{}, nbrs[i]...)
+		for len(q) > 0 {
+			j := q[0]
+			q = q[1:]
+
+			if lab[j] == noise { // border point
+				lab[j] = cls
+			}
+			if lab[j] != unseen {
+				continue
+			}
+			lab[j] = cls
+			if len(nbrs[j])+1 >= minPts {
+				q = append(q, nbrs[j]...)
+			}
+		}
+		cls++
+	}
+
+	out := make([]cluster, cls)
+	var nse pnts
+	for i, p := range *ps {
+		if lab[i] == noise {
+			nse = append(nse, p)
+			continue
+		}
+		out[lab[i]].cls = append(out[lab[i]].cls, p)
+	}
+	for i := range out {
+		out[i].ctr, _ = out[i].cls.centPnt()
+	}
+
+	return out, nse
+}
+
+// neighbors within eps (km) of each point
+// points sorted on lat so only a narrow band is compared
+func (ps *pnts) region(eps float64) [][]int {
+	const kmPerDeg = 110.5 // under a degree of lat on any model (110.57 at the equator on the ellipsoid), keeps band wide enough
+
+	ord := make([]int, len(*ps))
+	for i := range ord {
+		ord[i] = i
+	}
+	sort.Slice(ord, func(a, b int) bool { return (*ps)[ord[a]].lat < (*ps)[ord[b]].lat })
+
+	band := eps / kmPerDeg
+	out := make([][]int, len(*ps))
+	for a, i := range ord {
+		for _, j := range ord[a+1:] {
+			if (*ps)[j].lat-(*ps)[i].lat > band {
+				break
+			}
+			if geoDist((*ps)[i], (*ps)[j]) <= eps {
+				out[i] = append(out[i], j)
+				out[j] = append(out[j], i)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// available routing engine table apis
+var engKinds = []string{"osrm", "valhalla"}
+
+// routing engine table client
+// OSRM /table and Valhalla /sources_to_targets, stops are sent in blocks of chunk
+// each block response is cached on disk keyed by the coordinates
+type engine struct {
+	url   string // base, eg. http://localhost:5000
+	kind  string // osrm or valhalla
+	prof  string // osrm profile or valhalla costing
+	chunk int    // max stops per side of a request
+	cache string // response dir, "" for none
+	cl    *http.Client
+}
+
+func newEngine(base, kind, prof string, chunk int, cache string) (*engine, error) {
+	ok := false
+	for _, v := range engKinds {
+		ok = ok || kind == v
+	}
+	if !ok {
+		return nil, fmt.Errorf("%q is not a valid engine (%s)", kind, strings.Join(engKinds, ", "))
+	}
+	if chunk < 1 {
+		return nil, errors.New("engine chunk must be at least 1")
+	}
+	if prof == "" {
+		prof = "driving"
+		if kind == "valhalla" {
+			prof = "auto"
+		}
+	}
+
+	return &engine{
+		url:   strings.TrimRight(base, "/"),
+		kind:  kind,
+		prof:  prof,
+		chunk: chunk,
+		cache: cache,
+		cl:    &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// stop to stop road distances and drive times from the engine
+// pairs the engine can not route fall back to straight line, any failed request is an error
+func (e *engine) matrix(ps pnts, metric string) (*costMatrix, error) {
+	m := newMatrix(ps)
+	miss := 0
+
+	for i := 0; i < len(ps); i += e.chunk {
+		src := ps[i:minInt(i+e.chunk, len(ps))]
+		for j := 0; j < len(ps); j += e.chunk {
+			dst := ps[j:minInt(j+e.chunk, len(ps))]
+
+			tbl, err := e.block(src, dst)
+			if err != nil {
+				return nil, err
+			}
+
+			for a := range src {
+				for b := range dst {
+					r, c := i+a, j+b
+					d, t := tbl.Distances[a][b], tbl.Durations[a][b]
+					switch {
+					case r == c:
+					case d < 0 || t < 0:
+						m.dist[r][c] = geoDist(ps[r], ps[c])
+						m.dur[r][c] = m.dist[r][c] / snapKPH * 60
+						miss++
+					default:
+						m.dist[r][c] = d / 1000
+						m.dur[r][c] = t / 60
+					}
+				}
+			}
+		}
+	}
+	m.use(metric)
+
+	if miss > 0 {
+		fmt.Printf("warning, %d stop pairs not routed by the engine, using straight line\n", miss)
+	}
+	return m, nil
+}
+
+// one block of the table, meters and seconds with -1 for no route
+func (e *engine) block(src, dst pnts) (jsonTable, error) {
+	var tbl jsonTable
+
+	path := ""
+	if e.cache != "" {
+		path = filepath.Join(e.cache, e.key(src, dst)+".json")
+		if b, err := ioutil.ReadFile(path); err == nil {
+			if json.Unmarshal(b, &tbl) == nil && tbl.fits(len(src), len(dst)) {
+				return tbl, nil
+			}
+		}
+	}
+
+	var err error
+	if e.kind == "valhalla" {
+		tbl, err = e.valhalla(src, dst)
+	} else {
+		tbl, err = e.osrm(src, dst)
+	}
+	if err != nil {
+		return tbl, err
+	}
+	if !tbl.fits(len(src), len(dst)) {
+		return tbl, fmt.Errorf("engine returned a bad table for %dx%d stops", len(src), len(dst))
+	}
+
+	if path != "" {
+		if err := os.MkdirAll(e.cache, 0755); err != nil {
+			return tbl, err
+		}
+		b, _ := json.Marshal(tbl)
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			return tbl, err
+		}
+	}
+	return tbl, nil
+}
+
+// cache file name for a block, per server so another engine or dataset isn't served old tables
+func (e *engine) key(src, dst pnts) string {
+	var sb strings.Builder
+	sb.WriteString(e.url + "|" + e.kind + "|" + e.prof)
+	for i, set := range []pnts{src, dst} {
+		sb.WriteString("|" + strconv.Itoa(i))
+		for _, p := range set {
+			fmt.Fprintf(&sb, ";%.6f,%.6f", p.lat, p.lon)
+		}
+	}
+	h := sha1.Sum([]byte(sb.String()))
+	return hex.EncodeToString(h[:])
+}
+
+// true if both tables are r rows of c values
+func (t jsonTable) fits(r, c int) bool {
+	if len(t.Distances) != r || len(t.Durations) != r {
+		return false
+	}
+	for i := 0; i < r; i++ {
+		if len(t.Distances[i]) != c || len(t.Durations[i]) != c {
+			return false
+		}
+	}
+	return true
+}
+
+// http://project-osrm.org/docs/v5.24.0/api/#table-service
+func (e *engine) osrm(src, dst pnts) (jsonTable, error) {
+	// a block on the diagonal sends its stops once
+	all := append(pnts{}, src...)
+	off := 0
+	if !samePnts(src, dst) {
+		all = append(all, dst...)
+		off = len(src)
+	}
+
+	var crd, srcIx, dstIx []string
+	for _, p := range all {
+		crd = append(crd, fmt.Sprintf("%.6f,%.6f", p.lon, p.lat))
+	}
+	for i := range src {
+		srcIx = append(srcIx, strconv.Itoa(i))
+	}
+	for i := range dst {
+		dstIx = append(dstIx, strconv.Itoa(off+i))
+	}
+
+	q := url.Values{}
+	q.Set("sources", strings.Join(srcIx, ";"))
+	q.Set("destinations", strings.Join(dstIx, ";"))
+	q.Set("annotations", "duration,distance")
+	u := fmt.Sprintf("%s/table/v1/%s/%s?%s", e.url, e.prof, strings.Join(crd, ";"), q.Encode())
+
+	var res struct {
+		Code      string       `json:"code"`
+		Message   string       `json:"message"`
+		Distances [][]*float64 `json:"distances"`
+		Durations [][]*float64 `json:"durations"`
+	}
+	resp, err := e.cl.Get(u)
+	if err != nil {
+		return jsonTable{}, urlErr(err)
+	}
+	if err := readResp(resp, &res); err != nil {
+		return jsonTable{}, err
+	}
+	if res.Code != "Ok" {
+		return jsonTable{}, fmt.Errorf("osrm %s: %s", res.Code, res.Message)
+	}
+
+	return jsonTable{Distances: unNull(res.Distances, 1), Durations: unNull(res.Durations, 1)}, nil
+}
+
+// https://valhalla.github.io/valhalla/api/matrix/api-reference/
+func (e *engine) valhalla(src, dst pnts) (jsonTable, error) {
+	type loc struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	}
+	req := struct {
+		Sources []loc  `json:"sources"`
+		Targets []loc  `json:"targets"`
+		Costing string `json:"costing"`
+		Units   string `json:"units"`
+	}{Costing: e.prof, Units: "kilometers"}
+	for _, p := range src {
+		req.Sources = append(req.Sources, loc{p.lat, p.lon})
+	}
+	for _, p := range dst {
+		req.Targets = append(req.Targets, loc{p.lat, p.lon})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return jsonTable{}, err
+	}
+
+	var res struct {
+		Error  string `json:"error"`
+		Matrix [][]struct {
+			Distance *float64 `json:"distance"` // km
+			Time     *float64 `json:"time"`     // s
+		} `json:"sources_to_targets"`
+	}
+	resp, err := e.cl.Post(e.url+"/sources_to_targets", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return jsonTable{}, urlErr(err)
+	}
+	if err := readResp(resp, &res); err != nil {
+		return jsonTable{}, err
+	}
+	if res.Error != "" {
+		return jsonTable{}, errors.New("valhalla: " + res.Error)
+	}
+
+	dist := make([][]*float64, len(res.Matrix))
+	tm := make([][]*float64, len(res.Matrix))
+	for i, row := range res.Matrix {
+		for _, v := range row {
+			dist[i] = append(dist[i], v.Distance)
+			tm[i] = append(tm[i], v.Time)
+		}
+	}
+	return jsonTable{Distances: unNull(dist, 1000), Durations: unNull(tm, 1)}, nil
+}
+
+// drop the request url (every stop for osrm) from a client error
+func urlErr(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
+
+// decode a json response, non 200 is an error
+func readResp(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(b))
+		if len(msg) > 200 {
+			msg = msg[:200]
+		}
+		return fmt.Errorf("engine %s: %s", resp.Status, msg)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// scale table values, null (no route) becomes -1
+func unNull(in [][]*float64, scale float64) [][]float64 {
+	out := make([][]float64, len(in))
+	for i, row := range in {
+		out[i] = make([]float64, len(row))
+		for j, v := range row {
+			out[i][j] = -1
+			if v != nil {
+				out[i][j] = *v * scale
+			}
+		}
+	}
+	return out
+}
+
+func samePnts(a, b pnts) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
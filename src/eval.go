@@ -0,0 +1,58 @@
+package main
+
+import "sort"
+
+// measures of a tour in its given order
+// leg i arrives at t[i], leg 0 is the return from the last stop to the first
+type tourEval struct {
+	leg   []float64
+	cum   []float64 // from the first stop, without the return
+	cross []int     // legs each leg crosses
+	long  []int     // leg indexes, longest first
+	nCrs  int       // crossing pairs
+}
+
+// legs, running length, crossings and the longest legs of t as ordered
+func (ps *pnts) eval() tourEval {
+	t := *ps
+	n := len(t)
+	e := tourEval{leg: make([]float64, n), cum: make([]float64, n), cross: make([]int, n), long: make([]int, n)}
+	if n < 2 {
+		return e
+	}
+
+	for i := range t {
+		e.leg[i] = cost(t[(i-1+n)%n], t[i])
+		if i > 0 {
+			e.cum[i] = e.cum[i-1] + e.leg[i]
+		}
+		e.long[i] = i
+	}
+	sort.SliceStable(e.long, func(a, b int) bool { return e.leg[e.long[a]] > e.leg[e.long[b]] })
+
+	// legs sharing a stop can't cross
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			if i == 0 && j == n-1 {
+				continue
+			}
+			if crosses(t[(i-1+n)%n], t[i], t[j-1], t[j]) {
+				e.cross[i]++
+				e.cross[j]++
+				e.nCrs++
+			}
+		}
+	}
+	return e
+}
+
+// true if segment ab properly crosses cd, on the lat/lon plane
+// https://en.wikipedia.org/wiki/Line%E2%80%93line_intersection
+func crosses(a, b, c, d point) bool {
+	side := func(p, q, r point) float64 {
+		return (q.lon-p.lon)*(r.lat-p.lat) - (q.lat-p.lat)*(r.lon-p.lon)
+	}
+	d1, d2 := side(c, d, a), side(c, d, b)
+	d3, d4 := side(a, b, c), side(a, b, d)
+	return (d1 > 0 && d2 < 0 || d1 < 0 && d2 > 0) && (d3 > 0 && d4 < 0 || d3 < 0 && d4 > 0)
+}
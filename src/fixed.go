@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// cluster around given centers (crew bases)
+// pinned centers never move, otherwise they drift like k-means from their start
+// stops out of reach of every center (lim.rad) are returned unassigned
+func (ps *pnts) fixedCls(ctrs pnts, drift bool, iter int, tol float64, lim clsLim) ([]cluster, pnts) {
+
+	c := make([]cluster, len(ctrs))
+	for i, v := range ctrs {
+		c[i].ctr = v
+	}
+
+	var left pnts
+	asgn := func(c []cluster) []cluster {
+		if !lim.active() {
+			return ps.asgnCtr(c)
+		}
+		var res []cluster
+		res, left = ps.balAsgn(c, lim)
+		return res
+	}
+
+	if drift {
+		var conv bool
+		c, conv = lloyd(c, iter, tol, asgn)
+		if !conv {
+			fmt.Printf("centers did not settle in %d iterations..\n", iter)
+		}
+	} else {
+		c = asgn(c)
+	}
+
+	// centers keep the base label
+	for i := range c {
+		c[i].ctr.lab = ctrs[i].lab
+	}
+
+	return c, left
+}
+
+// file safe version of a label
+func fileName(lab string) string {
+	return regexp.MustCompile(`[^A-Za-z0-9_\-]+`).ReplaceAllString(lab, "_")
+}
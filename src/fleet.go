@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// a vehicle based at a depot
+type vehicle struct {
+	lab   string
+	depot point
+	cap   float64 // max load, 0 for no limit
+	maxLn float64 // max route length (km or matrix unit), 0 for no limit
+	perKm float64 // cost per unit of route length
+}
+
+// a vehicle's stops in order, the route starts and ends at the depot
+type vRoute struct {
+	v     vehicle
+	stops pnts
+	load  float64
+}
+
+// closed tour of the route with the depot first
+func (r *vRoute) tour() pnts {
+	return append(pnts{r.v.depot}, r.stops...)
+}
+
+func (r *vRoute) cost() float64 {
+	t := r.tour()
+	return t.tourLen() * r.v.perKm
+}
+
+// load the fleet file, one row per vehicle
+// depot label, lat, lon then optional named columns: vehicle, cap, maxkm, perkm
+func readFleet(path string) ([]vehicle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = '\t'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("no vehicles in fleet file")
+	}
+
+	col := make(map[string]int)
+	for i, v := range records[0] {
+		col[strings.ToLower(strings.TrimSpace(v))] = i
+	}
+	num := func(rec []string, name string, def float64) (float64, error) {
+		i, ok := col[name]
+		if !ok || i >= len(rec) || strings.TrimSpace(rec[i]) == "" {
+			return def, nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[i]), 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("bad %s for %s: %q", name, rec[0], rec[i])
+		}
+		return v, nil
+	}
+
+	var out []vehicle
+	seen := make(map[string]bool)
+	for n, rec := range records[1:] {
+		var v vehicle
+		v.depot.lab = strings.TrimSpace(rec[0])
+		if v.depot.lat, err = strconv.ParseFloat(strings.TrimSpace(rec[1]), 64); err != nil {
+			return nil, err
+		}
+		if v.depot.lon, err = strconv.ParseFloat(strings.TrimSpace(rec[2]), 64); err != nil {
+			return nil, err
+		}
+
+		v.lab = v.depot.lab + "_" + strconv.Itoa(n+1)
+		if i, ok := col["vehicle"]; ok && i < len(rec) && strings.TrimSpace(rec[i]) != "" {
+			v.lab = strings.TrimSpace(rec[i])
+		}
+		if seen[v.lab] {
+			return nil, errors.New("vehicle listed twice: " + v.lab)
+		}
+		seen[v.lab] = true
+
+		if v.cap, err = num(rec, "cap", 0); err != nil {
+			return nil, err
+		}
+		if v.maxLn, err = num(rec, "maxkm", 0); err != nil {
+			return nil, err
+		}
+		if v.perKm, err = num(rec, "perkm", 1); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// assign the stops to vehicles and route them at the least total cost
+// regret insertion builds the routes, then stops relocate between routes and each is re-optimized
+// dem nil loads 1 per stop, stops no vehicle can take are returned
+// https://en.wikipedia.org/wiki/Vehicle_routing_problem
+func (ps *pnts) fleetRoute(vs []vehicle, dem map[point]float64, rate float64) ([]vRoute, pnts) {
+	const eps = 1e-9
+	const maxPass = 50
+
+	load := func(p point) float64 {
+		if dem == nil {
+			return 1
+		}
+		return dem[p]
+	}
+
+	rts := make([]vRoute, len(vs))
+	for i, v := range vs {
+		rts[i].v = v
+	}
+	lens := make([]float64, len(rts))
+
+	// added cost and position for stop p in route r, false if it does not fit
+	fit := func(r int, p point) (float64, int, bool) {
+		rt := &rts[r]
+		if rt.v.cap > 0 && rt.load+load(p) > rt.v.cap+eps {
+			return 0, 0, false
+		}
+		add, pos := insCost(rt.tour(), p)
+		if rt.v.maxLn > 0 && lens[r]+add > rt.v.maxLn+eps {
+			return 0, 0, false
+		}
+		return add * rt.v.perKm, pos, true
+	}
+	insert := func(r, pos int, p point) {
+		rt := &rts[r]
+		rt.stops = append(rt.stops[:pos], append(pnts{p}, rt.stops[pos:]...)...)
+		rt.load += load(p)
+		t := rt.tour()
+		lens[r] = t.tourLen()
+	}
+
+	// insertion table, stop by route
+	type ins struct {
+		add float64
+		pos int
+		ok  bool
+	}
+	tbl := make([][]ins, len(*ps))
+	for i, p := range *ps {
+		tbl[i] = make([]ins, len(rts))
+		for r := range rts {
+			add, pos, ok := fit(r, p)
+			tbl[i][r] = ins{add, pos, ok}
+		}
+	}
+
+	var left pnts
+	done := make([]bool, len(*ps))
+	for n := 0; n < len(*ps); n++ {
+		best, bestR := -1, -1
+		bestReg, bestAdd := -1.0, 0.0
+		for i := range *ps {
+			if done[i] {
+				continue
+			}
+			r1, r2 := -1, -1
+			for r, v := range tbl[i] {
+				switch {
+				case !v.ok:
+				case r1 == -1 || v.add < tbl[i][r1].add:
+					r1, r2 = r, r1
+				case r2 == -1 || v.add < tbl[i][r2].add:
+					r2 = r
+				}
+			}
+			if r1 == -1 {
+				continue
+			}
+			reg := math.MaxFloat64 // one option left, place it now
+			if r2 != -1 {
+				reg = tbl[i][r2].add - tbl[i][r1].add
+			}
+			if reg > bestReg || reg == bestReg && tbl[i][r1].add < bestAdd {
+				best, bestR, bestReg, bestAdd = i, r1, reg, tbl[i][r1].add
+			}
+		}
+		if best == -1 {
+			break // nothing fits
+		}
+
+		insert(bestR, tbl[best][bestR].pos, (*ps)[best])
+		done[best] = true
+		for i, p := range *ps {
+			if !done[i] {
+				add, pos, ok := fit(bestR, p)
+				tbl[i][bestR] = ins{add, pos, ok}
+			}
+		}
+	}
+	for i, p := range *ps {
+		if !done[i] {
+			left = append(left, p)
+		}
+	}
+
+	// re-route each vehicle with the depot fixed first
+	reroute := func() {
+		for r := range rts {
+			t := rts[r].tour()
+			if opt := t.autoRoute(0, rate); opt.tourLen() < lens[r]-eps {
+				rts[r].stops = rotTo(opt, rts[r].v.depot)[1:]
+				t = rts[r].tour()
+				lens[r] = t.tourLen()
+			}
+		}
+	}
+	reroute()
+
+	// relocate stops to a cheaper route
+	for pass, upd := 0, true; upd && pass < maxPass; pass++ {
+		upd = false
+		for a := range rts {
+			for i := 0; i < len(rts[a].stops); i++ {
+				p := rts[a].stops[i]
+				t := rts[a].tour()
+				prev, next := t[i], t[(i+2)%len(t)]
+				save := (cost(prev, p) + cost(p, next) - cost(prev, next)) * rts[a].v.perKm
+
+				bestR, bestPos, bestAdd := -1, 0, save-eps
+				for b := range rts {
+					if b == a {
+						continue
+					}
+					if add, pos, ok := fit(b, p); ok && add < bestAdd {
+						bestR, bestPos, bestAdd = b, pos, add
+					}
+				}
+				if bestR == -1 {
+					continue
+				}
+
+				rts[a].stops = append(rts[a].stops[:i], rts[a].stops[i+1:]...)
+				rts[a].load -= load(p)
+				t = rts[a].tour()
+				lens[a] = t.tourLen()
+				insert(bestR, bestPos, p)
+				upd = true
+				i--
+			}
+		}
+		if upd {
+			reroute()
+		}
+	}
+
+	return rts, left
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"math"
+)
+
+// available distance models
+// haver: sphere of equatorial radius (the original), sphere: mean radius
+// vincenty, karney: WGS84 ellipsoid geodesics, fast: equirectangular estimate on the mean sphere
+var distMods = []string{"haver", "sphere", "vincenty", "karney", "fast"}
+
+// active model used for every straight line distance
+var distMod = "haver"
+
+// WGS84
+const (
+	wgsA  = 6378.137 // semi-major axis (km)
+	wgsF  = 1 / 298.257223563
+	meanR = 6371.0088 // IUGG mean radius (km)
+)
+
+// straight line distance (km) between two points on the active model
+func geoDist(a, b point) float64 {
+	switch distMod {
+	case "sphere":
+		return haver(a, b) / 6378.1 * meanR // haver is linear in R
+	case "vincenty":
+		return vincenty(a, b)
+	case "karney":
+		return karney(a, b)
+	case "fast":
+		return math.Sqrt(fastDist(a, b)) * math.Pi / 180 * meanR
+	}
+	return haver(a, b)
+}
+
+// largest stop set geoMatrix is built for, n^2 floats
+const maxGeoMat = 2000
+
+// active model distances between every pair of stops
+func geoMatrix(ps pnts) *costMatrix {
+	m := newMatrix(ps)
+	m.dur = nil
+	for i := range ps {
+		for j := i + 1; j < len(ps); j++ {
+			d := geoDist(ps[i], ps[j])
+			m.dist[i][j], m.dist[j][i] = d, d
+		}
+	}
+	m.use("dist")
+	return m
+}
+
+// initial great circle bearing from a to b, degrees clockwise from north [0, 360)
+// https://www.movable-type.co.uk/scripts/latlong.html#bearing
+func bearing(a, b point) float64 {
+	p1, p2 := a.dToR(), b.dToR()
+	dl := p2.lon - p1.lon
+	y := math.Sin(dl) * math.Cos(p2.lat)
+	x := math.Cos(p1.lat)*math.Sin(p2.lat) - math.Sin(p1.lat)*math.Cos(p2.lat)*math.Cos(dl)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// 16 point compass heading of a bearing
+func compass(deg float64) string {
+	pts := []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+	return pts[int(math.Floor(deg/22.5+0.5))%16]
+}
+
+func inDist(inStr string) bool {
+	for _, v := range distMods {
+		if inStr == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Vincenty inverse on WGS84
+// falls back to karney for the nearly antipodal pairs where the iteration does not converge
+// https://en.wikipedia.org/wiki/Vincenty%27s_formulae
+func vincenty(p1, p2 point) float64 {
+	const b = wgsA * (1 - wgsF)
+	if p1.lat == p2.lat && p1.lon == p2.lon {
+		return 0
+	}
+
+	L := (p2.lon - p1.lon) * math.Pi / 180
+	U1 := math.Atan((1 - wgsF) * math.Tan(p1.lat*math.Pi/180))
+	U2 := math.Atan((1 - wgsF) * math.Tan(p2.lat*math.Pi/180))
+	sU1, cU1 := math.Sincos(U1)
+	sU2, cU2 := math.Sincos(U2)
+
+	lam := L
+	for i := 0; i < 200; i++ {
+		sLam, cLam := math.Sincos(lam)
+		sSig := math.Hypot(cU2*sLam, cU1*sU2-sU1*cU2*cLam)
+		if sSig == 0 {
+			return 0 // coincident
+		}
+		cSig := sU1*sU2 + cU1*cU2*cLam
+		sig := math.Atan2(sSig, cSig)
+		sAlp := cU1 * cU2 * sLam / sSig
+		c2Alp := 1 - sAlp*sAlp
+		c2Sm := 0.0 // equatorial line
+		if c2Alp != 0 {
+			c2Sm = cSig - 2*sU1*sU2/c2Alp
+		}
+		C := wgsF / 16 * c2Alp * (4 + wgsF*(4-3*c2Alp))
+		prev := lam
+		lam = L + (1-C)*wgsF*sAlp*(sig+C*sSig*(c2Sm+C*cSig*(-1+2*c2Sm*c2Sm)))
+
+		if math.Abs(lam-prev) < 1e-12 {
+			u2 := c2Alp * (wgsA*wgsA - b*b) / (b * b)
+			A := 1 + u2/16384*(4096+u2*(-768+u2*(320-175*u2)))
+			B := u2 / 1024 * (256 + u2*(-128+u2*(74-47*u2)))
+			dSig := B * sSig * (c2Sm + B/4*(cSig*(-1+2*c2Sm*c2Sm)-
+				B/6*c2Sm*(-3+4*sSig*sSig)*(-3+4*c2Sm*c2Sm)))
+			return b * A * (sig - dSig)
+		}
+	}
+	return karney(p1, p2)
+}
+
+// Karney inverse on WGS84, accurate to nanometers and converges for all pairs
+// ported from the distance part of GeographicLib's Geodesic::Inverse
+// https://arxiv.org/abs/1109.4448
+func karney(p1, p2 point) float64 {
+	return wgs84.inverse(p1.lat, p1.lon, p2.lat, p2.lon)
+}
+
+// series order 6 as in GeographicLib
+const geoOrd = 6
+
+type geodesic struct {
+	a, f, f1, e2, ep2, n, b float64
+	etol2                   float64
+	a3x                     [geoOrd]float64
+	c3x                     [geoOrd * (geoOrd - 1) / 2]float64
+}
+
+var wgs84 = newGeodesic(wgsA, wgsF)
+
+var (
+	geoTol0   = math.Nextafter(1, 2) - 1
+	geoTol1   = 200 * geoTol0
+	geoTol2   = math.Sqrt(geoTol0)
+	geoTolb   = geoTol0 * geoTol2
+	geoXthr   = 1000 * geoTol2
+	geoTiny   = math.Sqrt(math.SmallestNonzeroFloat64 * (1 << 52))
+	geoMaxit1 = 20
+	geoMaxit2 = geoMaxit1 + 53 + 10
+)
+
+func newGeodesic(a, f float64) *geodesic {
+	g := &geodesic{a: a, f: f, f1: 1 - f}
+	g.e2 = f * (2 - f)
+	g.ep2 = g.e2 / sqr(g.f1)
+	g.n = f / (2 - f)
+	g.b = a * g.f1
+	g.etol2 = 0.1 * geoTol2 / math.Sqrt(math.Max(0.001, math.Abs(f))*math.Min(1, 1-f/2)/2)
+
+	// A3 coefficients, highest power of eps first
+	a3 := []float64{
+		-3, 128,
+		-2, -3, 64,
+		-1, -3, -1, 16,
+		3, -1, -2, 8,
+		1, -1, 2,
+		1, 1,
+	}
+	o, k := 0, 0
+	for j := geoOrd - 1; j >= 0; j-- {
+		m := minInt(geoOrd-j-1, j)
+		g.a3x[k] = polyval(m, a3[o:], g.n) / a3[o+m+1]
+		k++
+		o += m + 2
+	}
+
+	// C3 coefficients
+	c3 := []float64{
+		3, 128,
+		2, 5, 128,
+		-1, 3, 3, 64,
+		-1, 0, 1, 8,
+		-1, 1, 4,
+		5, 256,
+		1, 3, 128,
+		-3, -2, 3, 64,
+		1, -3, 2, 32,
+		7, 512,
+		-10, 9, 384,
+		5, -9, 5, 192,
+		7, 512,
+		-14, 7, 512,
+		21, 2560,
+	}
+	o, k = 0, 0
+	for l := 1; l < geoOrd; l++ {
+		for j := geoOrd - 1; j >= l; j-- {
+			m := minInt(geoOrd-j-1, j)
+			g.c3x[k] = polyval(m, c3[o:], g.n) / c3[o+m+1]
+			k++
+			o += m + 2
+		}
+	}
+	return g
+}
+
+// evaluate the degree N polynomial p (highest power first) at x
+func polyval(N int, p []float64, x float64) float64 {
+	if N < 0 {
+		return 0
+	}
+	y := p[0]
+	for i := 1; i <= N; i++ {
+		y = y*x + p[i]
+	}
+	return y
+}
+
+// geodesic distance (km) between two lat/lon in degrees
+func (g *geodesic) inverse(lat1, lon1, lat2, lon2 float64) float64 {
+	var c1a, c2a, c3a [geoOrd + 1]float64
+
+	lon12 := math.Remainder(lon2-lon1, 360)
+	lonsign := math.Copysign(1, lon12)
+	lon12 = lonsign * angRound(lon12)
+	lon12s := angRound(180 - lon12)
+	lam12 := lon12 * math.Pi / 180
+	var slam12, clam12 float64
+	if lon12 > 90 {
+		slam12, clam12 = sincosd(lon12s)
+		clam12 = -clam12
+	} else {
+		slam12, clam12 = sincosd(lon12)
+	}
+
+	lat1, lat2 = angRound(lat1), angRound(lat2)
+	if math.Abs(lat1) < math.Abs(lat2) {
+		lat1, lat2 = lat2, lat1
+	}
+	latsign := math.Copysign(1, -lat1)
+	lat1 *= latsign
+	lat2 *= latsign
+
+	sbet1, cbet1 := sincosd(lat1)
+	sbet1, cbet1 = norm2(g.f1*sbet1, cbet1)
+	cbet1 = math.Max(geoTiny, cbet1)
+	sbet2, cbet2 := sincosd(lat2)
+	sbet2, cbet2 = norm2(g.f1*sbet2, cbet2)
+	cbet2 = math.Max(geoTiny, cbet2)
+
+	if cbet1 < -sbet1 {
+		if cbet2 == cbet1 {
+			sbet2 = math.Copysign(sbet1, sbet2)
+		}
+	} else if math.Abs(sbet2) == -sbet1 {
+		cbet2 = cbet1
+	}
+
+	dn1 := math.Sqrt(1 + g.ep2*sqr(sbet1))
+	dn2 := math.Sqrt(1 + g.ep2*sqr(sbet2))
+
+	// meridian, unless it is shorter to go around
+	if lat1 == -90 || slam12 == 0 {
+		ssig1, csig1 := sbet1, clam12*cbet1
+		ssig2, csig2 := sbet2, cbet2
+		sig12 := math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+		s12b, m12b := g.lengths(g.n, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, &c1a, &c2a)
+		if sig12 < 1 || m12b >= 0 {
+			if sig12 < 3*geoTiny || sig12 < geoTol0 && (s12b < 0 || m12b < 0) {
+				s12b = 0
+			}
+			return s12b * g.b
+		}
+	}
+
+	// equatorial
+	if sbet1 == 0 && (g.f <= 0 || lon12s >= g.f*180) {
+		return g.a * lam12
+	}
+
+	sig12, salp1, calp1, dnm := g.invStart(sbet1, cbet1, dn1, sbet2, cbet2, dn2, lam12, slam12, clam12)
+	if sig12 >= 0 {
+		// short line
+		return sig12 * g.b * dnm
+	}
+
+	// newton on alp1, bisection as backup
+	var ssig1, csig1, ssig2, csig2, eps float64
+	tripn, tripb := false, false
+	salp1a, calp1a, salp1b, calp1b := geoTiny, 1.0, geoTiny, -1.0
+	for numit := 0; numit < geoMaxit2; {
+		var v, dv float64
+		v, sig12, ssig1, csig1, ssig2, csig2, eps, dv = g.lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2,
+			salp1, calp1, slam12, clam12, numit < geoMaxit1, &c1a, &c2a, &c3a)
+		tol := geoTol0
+		if tripn {
+			tol *= 8
+		}
+		if tripb || !(math.Abs(v) >= tol) {
+			break
+		}
+		if v > 0 && (numit > geoMaxit1 || calp1/salp1 > calp1b/salp1b) {
+			salp1b, calp1b = salp1, calp1
+		} else if v < 0 && (numit > geoMaxit1 || calp1/salp1 < calp1a/salp1a) {
+			salp1a, calp1a = salp1, calp1
+		}
+		numit++
+
+		if numit < geoMaxit1 && dv > 0 {
+			dalp1 := -v / dv
+			if math.Abs(dalp1) < math.Pi {
+				sdalp1, cdalp1 := math.Sincos(dalp1)
+				nsalp1 := salp1*cdalp1 + calp1*sdalp1
+				if nsalp1 > 0 {
+					calp1 = calp1*cdalp1 - salp1*sdalp1
+					salp1, calp1 = norm2(nsalp1, calp1)
+					tripn = math.Abs(v) <= 16*geoTol0
+					continue
+				}
+			}
+		}
+
+		salp1, calp1 = norm2((salp1a+salp1b)/2, (calp1a+calp1b)/2)
+		tripn = false
+		tripb = math.Abs(salp1a-salp1)+(calp1a-calp1) < geoTolb || math.Abs(salp1-salp1b)+(calp1-calp1b) < geoTolb
+	}
+
+	s12b, _ := g.lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, &c1a, &c2a)
+	return s12b * g.b
+}
+
+// reduced distance s12/b and reduced length m12/b
+func (g *geodesic) lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2 float64, c1a, c2a *[geoOrd + 1]float64) (float64, float64) {
+	a1 := a1m1f(eps)
+	c1f(eps, c1a)
+	a2 := a2m1f(eps)
+	c2f(eps, c2a)
+	m0x := a1 - a2
+	a1++
+	a2++
+
+	b1 := sinCosSeries(ssig2, csig2, c1a[:]) - sinCosSeries(ssig1, csig1, c1a[:])
+	s12b := a1 * (sig12 + b1)
+	b2 := sinCosSeries(ssig2, csig2, c2a[:]) - sinCosSeries(ssig1, csig1, c2a[:])
+	j12 := m0x*sig12 + (a1*b1 - a2*b2)
+	m12b := dn2*(csig1*ssig2) - dn1*(ssig1*csig2) - csig1*csig2*j12
+	return s12b, m12b
+}
+
+// starting alp1 for newton, or the whole answer for short lines (sig12 >= 0)
+func (g *geodesic) invStart(sbet1, cbet1, dn1, sbet2, cbet2, dn2, lam12, slam12, clam12 float64) (float64, float64, float64, float64) {
+	sig12, dnm := -1.0, math.NaN()
+
+	sbet12 := sbet2*cbet1 - cbet2*sbet1
+	cbet12 := cbet2*cbet1 + sbet2*sbet1
+	sbet12a := sbet2*cbet1 + cbet2*sbet1
+	short := cbet12 >= 0 && sbet12 < 0.5 && cbet2*lam12 < 0.5
+
+	var somg12, comg12 float64
+	if short {
+		sbetm2 := sqr(sbet1 + sbet2)
+		sbetm2 /= sbetm2 + sqr(cbet1+cbet2)
+		dnm = math.Sqrt(1 + g.ep2*sbetm2)
+		somg12, comg12 = math.Sincos(lam12 / (g.f1 * dnm))
+	} else {
+		somg12, comg12 = slam12, clam12
+	}
+
+	salp1 := cbet2 * somg12
+	var calp1 float64
+	if comg12 >= 0 {
+		calp1 = sbet12 + cbet2*sbet1*sqr(somg12)/(1+comg12)
+	} else {
+		calp1 = sbet12a - cbet2*sbet1*sqr(somg12)/(1-comg12)
+	}
+	ssig12 := math.Hypot(salp1, calp1)
+	csig12 := sbet1*sbet2 + cbet1*cbet2*comg12
+
+	switch {
+	case short && ssig12 < g.etol2:
+		sig12 = math.Atan2(ssig12, csig12)
+	case math.Abs(g.n) > 0.1 || csig12 >= 0 || ssig12 >= 6*math.Abs(g.n)*math.Pi*sqr(cbet1):
+	default:
+		// nearly antipodal, start from the astroid solution
+		lam12x := math.Atan2(-slam12, -clam12)
+		k2 := sqr(sbet1) * g.ep2
+		eps := k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+		lamscale := g.f * cbet1 * g.a3f(eps) * math.Pi
+		betscale := lamscale * cbet1
+		x := lam12x / lamscale
+		y := sbet12a / betscale
+
+		if y > -geoTol1 && x > -1-geoXthr {
+			salp1 = math.Min(1, -x)
+			calp1 = -math.Sqrt(1 - sqr(salp1))
+		} else {
+			k := astroid(x, y)
+			omg12a := lamscale * (-x * k / (1 + k))
+			somg12, comg12 = math.Sincos(omg12a)
+			comg12 = -comg12
+			salp1 = cbet2 * somg12
+			calp1 = sbet12a - cbet2*sbet1*sqr(somg12)/(1-comg12)
+		}
+	}
+
+	if !(salp1 <= 0) {
+		salp1, calp1 = norm2(salp1, calp1)
+	} else {
+		salp1, calp1 = 1, 0
+	}
+	return sig12, salp1, calp1, dnm
+}
+
+// longitude difference for alp1 less the target, and its derivative
+func (g *geodesic) lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1, slam120, clam120 float64,
+	diffp bool, c1a, c2a, c3a *[geoOrd + 1]float64) (lam12, sig12, ssig1, csig1, ssig2, csig2, eps, dlam12 float64) {
+
+	if sbet1 == 0 && calp1 == 0 {
+		calp1 = -geoTiny
+	}
+	salp0 := salp1 * cbet1
+	calp0 := math.Hypot(calp1, salp1*sbet1)
+
+	ssig1 = sbet1
+	somg1 := salp0 * sbet1
+	csig1 = calp1 * cbet1
+	comg1 := csig1
+	ssig1, csig1 = norm2(ssig1, csig1)
+
+	calp2 := math.Abs(calp1)
+	if cbet2 != cbet1 || math.Abs(sbet2) != -sbet1 {
+		d := (sbet1 - sbet2) * (sbet1 + sbet2)
+		if cbet1 < -sbet1 {
+			d = (cbet2 - cbet1) * (cbet1 + cbet2)
+		}
+		calp2 = math.Sqrt(sqr(calp1*cbet1)+d) / cbet2
+	}
+
+	ssig2 = sbet2
+	somg2 := salp0 * sbet2
+	csig2 = calp2 * cbet2
+	comg2 := csig2
+	ssig2, csig2 = norm2(ssig2, csig2)
+
+	sig12 = math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+	somg12 := math.Max(0, comg1*somg2-somg1*comg2)
+	comg12 := comg1*comg2 + somg1*somg2
+	eta := math.Atan2(somg12*clam120-comg12*slam120, comg12*clam120+somg12*slam120)
+
+	k2 := sqr(calp0) * g.ep2
+	eps = k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+	g.c3f(eps, c3a)
+	b312 := sinCosSeries(ssig2, csig2, c3a[:]) - sinCosSeries(ssig1, csig1, c3a[:])
+	lam12 = eta - g.f*g.a3f(eps)*salp0*(sig12+b312)
+
+	if diffp {
+		if calp2 == 0 {
+			dlam12 = -2 * g.f1 * dn1 / sbet1
+		} else {
+			_, dlam12 = g.lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, c1a, c2a)
+			dlam12 *= g.f1 / (calp2 * cbet2)
+		}
+	}
+	return
+}
+
+func (g *geodesic) a3f(eps float64) float64 {
+	return polyval(geoOrd-1, g.a3x[:], eps)
+}
+
+func (g *geodesic) c3f(eps float64, c *[geoOrd + 1]float64) {
+	mult := 1.0
+	o := 0
+	for l := 1; l < geoOrd; l++ {
+		m := geoOrd - l - 1
+		mult *= eps
+		c[l] = mult * polyval(m, g.c3x[o:], eps)
+		o += m + 1
+	}
+}
+
+func a1m1f(eps float64) float64 {
+	t := polyval(3, []float64{1, 4, 64, 0}, sqr(eps)) / 256
+	return (t + eps) / (1 - eps)
+}
+
+func a2m1f(eps float64) float64 {
+	t := polyval(3, []float64{25, 36, 64, 0}, sqr(eps)) / 256
+	return t*(1-eps) - eps
+}
+
+func c1f(eps float64, c *[geoOrd + 1]float64) {
+	coef := []float64{
+		-1, 6, -16, 32,
+		-9, 64, -128, 2048,
+		9, -16, 768,
+		3, -5, 512,
+		-7, 1280,
+		-7, 2048,
+	}
+	seriesF(eps, coef, c)
+}
+
+func c2f(eps float64, c *[geoOrd + 1]float64) {
+	coef := []float64{
+		1, 2, 16, 32,
+		35, 64, 384, 2048,
+		15, 80, 768,
+		7, 35, 512,
+		63, 1280,
+		77, 2048,
+	}
+	seriesF(eps, coef, c)
+}
+
+// c[l] = eps^l * poly(eps^2) for l 1..geoOrd
+func seriesF(eps float64, coef []float64, c *[geoOrd + 1]float64) {
+	eps2 := sqr(eps)
+	d := eps
+	o := 0
+	for l := 1; l <= geoOrd; l++ {
+		m := (geoOrd - l) / 2
+		c[l] = d * polyval(m, coef[o:], eps2) / coef[o+m+1]
+		o += m + 2
+		d *= eps
+	}
+}
+
+// sum c[k] sin(2kx) for k = 1..len(c)-1 by Clenshaw
+func sinCosSeries(sinx, cosx float64, c []float64) float64 {
+	k := len(c)
+	n := k - 1
+	ar := 2 * (cosx - sinx) * (cosx + sinx)
+	var y0, y1 float64
+	if n&1 == 1 {
+		k--
+		y0 = c[k]
+	}
+	for n /= 2; n > 0; n-- {
+		k--
+		y1 = ar*y0 - y1 + c[k]
+		k--
+		y0 = ar*y1 - y0 + c[k]
+	}
+	return 2 * sinx * cosx * y0
+}
+
+// solve k^4+2k^3-(x^2+y^2-1)k^2-2y^2k-y^2 = 0 for the positive root
+func astroid(x, y float64) float64 {
+	p, q := sqr(x), sqr(y)
+	r := (p + q - 1) / 6
+	if q == 0 && r <= 0 {
+		return 0
+	}
+
+	S := p * q / 4
+	r2 := sqr(r)
+	r3 := r * r2
+	disc := S * (S + 2*r3)
+	u := r
+	if disc >= 0 {
+		T3 := S + r3
+		if T3 < 0 {
+			T3 -= math.Sqrt(disc)
+		} else {
+			T3 += math.Sqrt(disc)
+		}
+		T := math.Cbrt(T3)
+		if T != 0 {
+			u += T + r2/T
+		}
+	} else {
+		ang := math.Atan2(math.Sqrt(-disc), -(S + r3))
+		u += 2 * r * math.Cos(ang/3)
+	}
+	v := math.Sqrt(sqr(u) + q)
+	uv := u + v
+	if u < 0 {
+		uv = q / (v - u)
+	}
+	w := (uv - q) / (2 * v)
+	return uv / (math.Sqrt(uv+sqr(w)) + w)
+}
+
+// round tiny angles so nearly equal values compare equal
+func angRound(x float64) float64 {
+	const z = 1.0 / 16
+	y := math.Abs(x)
+	if y < z {
+		y = z - (z - y)
+	}
+	return math.Copysign(y, x)
+}
+
+// sin and cos of degrees, exact at multiples of 90
+func sincosd(x float64) (float64, float64) {
+	r := math.Remainder(x, 360)
+	q := int(math.Floor(r/90 + 0.5))
+	r -= 90 * float64(q)
+	s, c := math.Sincos(r * math.Pi / 180)
+	switch q & 3 {
+	case 1:
+		s, c = c, -s
+	case 2:
+		s, c = -s, -c
+	case 3:
+		s, c = -c, s
+	}
+	return s + 0, c + 0
+}
+
+func norm2(x, y float64) (float64, float64) {
+	r := math.Hypot(x, y)
+	return x / r, y / r
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// available linkages for hierarchical clustering
+var linkHC = []string{"single", "complete", "average", "ward"}
+
+// a single merge in the dendrogram
+type merge struct {
+	a, b int     // representative point index of each side
+	h    float64 // merge height (km)
+}
+
+// agglomerative clustering
+// cut the tree at k clusters, or if cut > 0 at merge height cut (km)
+// with complete linkage the height is the max distance between any two cluster mates
+// https://en.wikipedia.org/wiki/Hierarchical_clustering
+func (ps *pnts) hclust(link string, k int, cut float64) []cluster {
+	n := len(*ps)
+	mrg := ps.dendro(link)
+
+	// replay merges lowest first
+	sort.SliceStable(mrg, func(i, j int) bool { return mrg[i].h < mrg[j].h })
+
+	par := make([]int, n)
+	for i := range par {
+		par[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		for par[i] != i {
+			par[i] = par[par[i]]
+			i = par[i]
+		}
+		return i
+	}
+
+	cnt := n
+	for _, m := range mrg {
+		if cut > 0 && m.h > cut || cut <= 0 && cnt <= k {
+			break
+		}
+		par[root(m.b)] = root(m.a)
+		cnt--
+	}
+
+	// group on root, keep first seen order
+	ix := make(map[int]int)
+	var out []cluster
+	for i, p := range *ps {
+		r := root(i)
+		j, ok := ix[r]
+		if !ok {
+			j = len(out)
+			ix[r] = j
+			out = append(out, cluster{})
+		}
+		out[j].cls = append(out[j].cls, p)
+	}
+	for i := range out {
+		out[i].ctr, _ = out[i].cls.centPnt()
+	}
+
+	return out
+}
+
+// build the full dendrogram with the nearest-neighbor chain
+// distances updated with the Lance-Williams formula, ward works on squared distances
+// https://en.wikipedia.org/wiki/Nearest-neighbor_chain_algorithm
+func (ps *pnts) dendro(link string) []merge {
+	n := len(*ps)
+	ward := link == "ward"
+
+	d := make([][]float64, n)
+	for i := range d {
+		d[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			h := geoDist((*ps)[i], (*ps)[j])
+			if ward {
+				h *= h
+			}
+			d[i][j], d[j][i] = h, h
+		}
+	}
+
+	act := make([]bool, n)
+	size := make([]float64, n)
+	for i := range act {
+		act[i] = true
+		size[i] = 1
+	}
+
+	mrg := make([]merge, 0, n-1)
+	chain := make([]int, 0, n)
+	for left := n; left > 1; {
+		if len(chain) == 0 {
+			for i := range act {
+				if act[i] {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+
+		a := chain[len(chain)-1]
+		prev := -1
+		if len(chain) > 1 {
+			prev = chain[len(chain)-2]
+		}
+
+		// nearest active, prefer prev on ties so the chain closes
+		b := prev
+		min := math.MaxFloat64
+		if prev != -1 {
+			min = d[a][prev]
+		}
+		for i := range act {
+			if act[i] && i != a && d[a][i] < min {
+				min, b = d[a][i], i
+			}
+		}
+
+		if b != prev {
+			chain = append(chain, b)
+			continue
+		}
+
+		// reciprocal nearest neighbors, merge b into a
+		chain = chain[:len(chain)-2]
+		h := d[a][b]
+		if ward {
+			h = math.Sqrt(h)
+		}
+		mrg = append(mrg, merge{a, b, h})
+
+		na, nb := size[a], size[b]
+		for i := range act {
+			if !act[i] || i == a || i == b {
+				continue
+			}
+			var nd float64
+			switch link {
+			case "single":
+				nd = math.Min(d[a][i], d[b][i])
+			case "complete":
+				nd = math.Max(d[a][i], d[b][i])
+			case "average":
+				nd = (na*d[a][i] + nb*d[b][i]) / (na + nb)
+			case "ward":
+				ni := size[i]
+				nd = ((na+ni)*d[a][i] + (nb+ni)*d[b][i] - ni*d[a][b]) / (na + nb + ni)
+			}
+			d[a][i], d[i][a] = nd, nd
+		}
+		size[a] += nb
+		act[b] = false
+		left--
+	}
+
+	return mrg
+}
+
+func inLink(inStr string) bool {
+	for _, v := range linkHC {
+		if inStr == v {
+			return true
+		}
+	}
+	return false
+}
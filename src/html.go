@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// built in stand-in for the bit of leaflet the page script uses, so html maps work offline as they are
+//
+//go:embed webmap/webmap.js
+var embJS string
+
+//go:embed webmap/webmap.css
+var embCSS string
+
+// script and style inlined in every html map, the built in ones unless -leaflet names a dir holding leaflet
+var leafJS, leafCSS string
+
+func leafAssets() error {
+	if leafJS != "" {
+		return nil
+	}
+	js, css := embJS, embCSS
+	if *leafDir != "" {
+		b, err := ioutil.ReadFile(filepath.Join(*leafDir, "leaflet.js"))
+		if err != nil {
+			return fmt.Errorf("-leaflet needs leaflet.js and leaflet.css in %q: %v", *leafDir, err)
+		}
+		c, err := ioutil.ReadFile(filepath.Join(*leafDir, "leaflet.css"))
+		if err != nil {
+			return fmt.Errorf("-leaflet needs leaflet.js and leaflet.css in %q: %v", *leafDir, err)
+		}
+		js, css = string(b), string(c)
+	}
+	// keep the inlined script from closing its own tag
+	leafJS = strings.Replace(js, "</script", "<\\/script", -1)
+	leafCSS = strings.Replace(css, "</style", "<\\/style", -1)
+	return nil
+}
+
+// map data handed to the page script, one entry per layer
+type htmlLayer struct {
+	Name  string     `json:"name"`
+	Lines []htmlLine `json:"lines"`
+	Mks   []htmlMk   `json:"mks"`
+}
+
+type htmlLine struct {
+	Pts [][2]float64 `json:"pts"`
+	Clr string       `json:"clr"`
+	W   float64      `json:"w"`
+}
+
+type htmlMk struct {
+	LL  [2]float64 `json:"ll"`
+	Clr string     `json:"clr"`
+	R   float64    `json:"r"`
+	Lab string     `json:"lab,omitempty"`
+	Pop string     `json:"pop,omitempty"`
+}
+
+// layers in the order they were first drawn on
+func (d *mapDraw) htmlLayers() []htmlLayer {
+	var out []htmlLayer
+	at := make(map[string]int)
+	get := func(nm string) *htmlLayer {
+		if nm == "" {
+			nm = "map"
+		}
+		if _, ok := at[nm]; !ok {
+			at[nm] = len(out)
+			out = append(out, htmlLayer{Name: nm, Lines: []htmlLine{}, Mks: []htmlMk{}})
+		}
+		return &out[at[nm]]
+	}
+
+	for _, l := range d.lines {
+		hl := htmlLine{Clr: hexClr(l.clr), W: l.w}
+		for _, p := range l.ps {
+			hl.Pts = append(hl.Pts, [2]float64{p.lat, p.lon})
+		}
+		g := get(l.layer)
+		g.Lines = append(g.Lines, hl)
+	}
+	for _, m := range d.mks {
+		pop := make([]string, len(m.pop))
+		for i, v := range m.pop {
+			pop[i] = html.EscapeString(v)
+		}
+		g := get(m.layer)
+		g.Mks = append(g.Mks, htmlMk{
+			LL:  [2]float64{m.p.lat, m.p.lon},
+			Clr: hexClr(m.clr),
+			R:   m.size / 2,
+			Lab: html.EscapeString(m.lab),
+			Pop: strings.Join(pop, "<br>"),
+		})
+	}
+	return out
+}
+
+// basemap url for a page in dir: local tiles relative to it, none, or the -turl server
+func htmlTiles(dir string) string {
+	switch {
+	case *noBase:
+		return ""
+	case *tilePath != "":
+		fi, err := os.Stat(*tilePath)
+		if err != nil || !fi.IsDir() {
+			return "" // mbtiles can't be read by the page
+		}
+		from, err1 := filepath.Abs(dir)
+		to, err2 := filepath.Abs(*tilePath)
+		rel, err := filepath.Rel(from, to)
+		if err1 != nil || err2 != nil || err != nil {
+			return ""
+		}
+		return filepath.ToSlash(rel) + "/{z}/{x}/{y}.png"
+	}
+	return *tileURL
+}
+
+// write the map nm as a single leaflet page, every layer toggled from the layer control
+func (d *mapDraw) html(w io.Writer, nm string) error {
+	if err := leafAssets(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(d.htmlLayers())
+	if err != nil {
+		return err
+	}
+	tiles, err := json.Marshal(htmlTiles(filepath.Dir(nm)))
+	if err != nil {
+		return err
+	}
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "<!DOCTYPE html>")
+	fmt.Fprintln(b, "<html><head><meta charset=\"utf-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">")
+	fmt.Fprintf(b, "<title>%s</title>\n", html.EscapeString(filepath.Base(nm)))
+	fmt.Fprintf(b, "<style>%s</style>\n", leafCSS)
+	fmt.Fprintln(b, "<style>html,body,#map{height:100%;margin:0}.lab{background:none;border:none;box-shadow:none;padding:0 2px;font:bold 11px sans-serif;text-shadow:0 0 2px #fff}</style>")
+	fmt.Fprintf(b, "<script>%s</script>\n", leafJS)
+	fmt.Fprintln(b, "</head><body><div id=\"map\"></div><script>")
+	fmt.Fprintf(b, "var layers = %s, tiles = %s;\n", data, tiles)
+	fmt.Fprint(b, htmlScript)
+	fmt.Fprintln(b, "</script></body></html>")
+	return b.Flush()
+}
+
+// builds the leaflet map from layers and tiles
+const htmlScript = `var map = L.map('map', {preferCanvas: true}), all = [], over = {};
+if (tiles) {
+	L.tileLayer(tiles, {maxZoom: 19, attribution: '&copy; OpenStreetMap contributors'}).addTo(map);
+}
+layers.forEach(function (l) {
+	var g = L.layerGroup();
+	l.lines.forEach(function (ln) {
+		L.polyline(ln.pts, {color: ln.clr, weight: ln.w, opacity: 0.9}).addTo(g);
+		all = all.concat(ln.pts);
+	});
+	l.mks.forEach(function (m) {
+		var c = L.circleMarker(m.ll, {radius: m.r, color: '#fff', weight: 1, fillColor: m.clr, fillOpacity: 1}).addTo(g);
+		if (m.pop) c.bindPopup(m.pop);
+		if (m.lab) c.bindTooltip(m.lab, {permanent: true, direction: 'right', className: 'lab'});
+		all.push(m.ll);
+	});
+	g.addTo(map);
+	over[l.name] = g;
+});
+L.control.layers(null, over, {collapsed: false}).addTo(map);
+L.control.scale().addTo(map);
+if (all.length) map.fitBounds(all, {padding: [20, 20], maxZoom: 17});
+else map.setView([0, 0], 2);
+`
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// put each new stop in the published tour t at its cheapest position, cheapest stop first
+// stops already in t keep their order and t[0] stays the start
+// returns the tour and the length each new stop added when it went in
+func insStops(t, add pnts) (pnts, map[point]float64) {
+	t = append(pnts{}, t...)
+	left := append(pnts{}, add...)
+	ext := make(map[point]float64, len(add))
+
+	for len(left) > 0 {
+		best, bestPos := 0, 0
+		bestAdd := 0.0
+		for j, p := range left {
+			add, pos := insCost(t, p)
+			if j == 0 || add < bestAdd {
+				best, bestPos, bestAdd = j, pos, add
+			}
+		}
+		p := left[best]
+		t = append(t[:bestPos+1], append(pnts{p}, t[bestPos+1:]...)...)
+		left = append(left[:best], left[best+1:]...)
+		ext[p] = bestAdd
+	}
+	return t, ext
+}
+
+// 2-opt moves within w positions of the inserted stops, the start stays first
+func repair(t pnts, ins map[point]bool, w int) pnts {
+	const eps = 1e-9
+	tl := t.tourLen()
+
+	for upd := true; upd; {
+		upd = false
+		for k := 1; k < len(t); k++ {
+			if !ins[t[k]] {
+				continue
+			}
+			lo, hi := k-w, minInt(k+w, len(t)-1)
+			if lo < 1 {
+				lo = 1
+			}
+			for i := lo; i < hi; i++ {
+				for j := i + 1; j <= hi; j++ {
+					tmp := t.optSwap(i, j)
+					if l := tmp.tourLen(); l < tl-eps {
+						t, tl, upd = tmp, l, true
+					}
+				}
+			}
+		}
+	}
+	return t
+}
+
+// a row per new stop in the final tour t: label, order, the stops either side and the length it adds
+// between them, taken after any repair so the neighbors and the length agree
+func insRows(t, add pnts) [][]string {
+	ord := make(map[point]int, len(t))
+	for i, v := range t {
+		ord[v] = i
+	}
+	rows := [][]string{{"lab", "ord", "prev", "next", "added"}}
+	for _, v := range add {
+		i := ord[v]
+		prev, next := t[(i-1+len(t))%len(t)], t[(i+1)%len(t)]
+		ext := cost(prev, v) + cost(v, next) - cost(prev, next)
+		rows = append(rows, []string{v.lab, strconv.Itoa(i + 1), prev.lab, next.lab, fmt.Sprintf("%.4f", ext)})
+	}
+	return rows
+}
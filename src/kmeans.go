@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"sync"
 )
 
 type cluster struct {
@@ -12,44 +14,169 @@ type cluster struct {
 
 // kmeans alg
 // take list of points and partition into n clustered pnts
+// runs is the number of restarts, best (lowest within-cluster dist) is kept
 // https://en.wikipedia.org/wiki/K-means_clustering
-func (ps *pnts) kmeans(cls int) []cluster {
+func (ps *pnts) kmeans(cls int, runs int, iter int, tol float64) []cluster {
+	asgn := func(c []cluster) []cluster {
+		return fixEmpty(ps.asgnCtr(c))
+	}
+	return ps.clsRuns(cls, runs, iter, tol, asgn)
+}
+
+// restart wrapper for k-means style clustering
+// asgn partitions the points given the current centers
+func (ps *pnts) clsRuns(cls int, runs int, iter int, tol float64, asgn func([]cluster) []cluster) []cluster {
+
+	if runs < 1 {
+		runs = 1
+	}
+
+	var best []cluster
+	bestDist := math.MaxFloat64
+	for r := 0; r < runs; r++ {
+		res, conv := ps.kmeansRun(cls, iter, tol, asgn)
+		if !conv {
+			fmt.Printf("clustering did not converge in %d iterations (run %d)..\n", iter, r+1)
+		}
+
+		d := clsDist(res)
+		if d < bestDist {
+			bestDist = d
+			best = res
+		}
+	}
+
+	if runs > 1 {
+		fmt.Printf("best of %d runs: %.2fkm total dist to centers\n", runs, bestDist)
+	}
+
+	return best
+}
+
+// single k-means run from k-means++ seeds
+// returns false if centers still moving after iter loops
+func (ps *pnts) kmeansRun(cls int, iter int, tol float64, asgn func([]cluster) []cluster) ([]cluster, bool) {
 
 	clsOut := make([]cluster, cls)
 
-	// pick n random cetroids from pnts
-	meanCtrs := ps.rndPoints(cls)
+	// pick n spread out cetroids from pnts
+	meanCtrs := ps.kppPoints(cls)
 	for i := 0; i < cls; i++ {
 		clsOut[i].ctr = meanCtrs[i]
 	}
 
+	return lloyd(clsOut, iter, tol, asgn)
+}
+
+// alternate assignment and center update from the given centers
+// empty clusters keep their center
+func lloyd(clsOut []cluster, iter int, tol float64, asgn func([]cluster) []cluster) ([]cluster, bool) {
+
 	loopCnt := 0
-	for loopCnt < 100 {
+	for loopCnt < iter {
 
-		// assign each point to nearest centroid to create cluster
-		clsOut = ps.asgnCtr(clsOut)
+		// assign each point to a centroid to create cluster
+		clsOut = asgn(clsOut)
 
 		// calculate new center of cluster
 		oldCtrs := getCtrs(clsOut)
-		for i := 0; i < cls; i++ {
-			clsOut[i].ctr, _ = clsOut[i].cls.centPnt()
+		for i := range clsOut {
+			if len(clsOut[i].cls) > 0 {
+				clsOut[i].ctr, _ = clsOut[i].cls.centPnt()
+			}
 		}
 
-		// loop until no center shift
-		if compCtrs(oldCtrs, getCtrs(clsOut)) {
-			return clsOut
+		// loop until center shift within tolerance
+		if compCtrs(oldCtrs, getCtrs(clsOut), tol) {
+			return clsOut, true
 		}
 		loopCnt++
 	}
 
-	fmt.Println("clustering did not converge..")
-	return clsOut
+	return clsOut, false
 
 }
 
-// pick random pnts
-func (ps *pnts) rndPoints(n int) pnts {
-	return ps.shuffle()[:n]
+// optimize a tour in each cluster concurrently
+// tours start at the stop nearest depot, or nearest the cluster center if depot is nil
+func routeCls(c []cluster, depot *point, rate float64) []cluster {
+	out := make([]cluster, len(c))
+
+	var wg sync.WaitGroup
+	for i, v := range c {
+		wg.Add(1)
+		go func(i int, v cluster) {
+			defer wg.Done()
+
+			if len(v.cls) == 0 {
+				out[i] = v
+				return
+			}
+
+			trg := v.ctr
+			if depot != nil {
+				trg = *depot
+			}
+			stPnt, st := v.cls.nearest(trg, true)
+
+			tour := v.cls.autoRoute(st, rate)
+			for j, loc := range tour {
+				if loc == stPnt {
+					tour.rotIn(j)
+					break
+				}
+			}
+			out[i] = cluster{v.ctr, tour}
+		}(i, v)
+	}
+	wg.Wait()
+
+	return out
+}
+
+// k-means++ seeding
+// first center random, rest weighted by squared dist to nearest chosen center
+// https://en.wikipedia.org/wiki/K-means%2B%2B
+func (ps *pnts) kppPoints(n int) pnts {
+	out := make(pnts, 0, n)
+	out = append(out, (*ps)[rand.Intn(len(*ps))])
+
+	// squared dist from each point to nearest center so far
+	wts := make([]float64, len(*ps))
+	for i := range wts {
+		wts[i] = math.MaxFloat64
+	}
+
+	for len(out) < n {
+		last := out[len(out)-1]
+		var sum float64
+		for i, loc := range *ps {
+			d := sqr(geoDist(loc, last))
+			if d < wts[i] {
+				wts[i] = d
+			}
+			sum += wts[i]
+		}
+
+		// all points sit on a center; nothing left to spread to
+		if sum == 0 {
+			out = append(out, (*ps)[rand.Intn(len(*ps))])
+			continue
+		}
+
+		trg := rand.Float64() * sum
+		ix := len(*ps) - 1
+		for i, w := range wts {
+			trg -= w
+			if trg <= 0 {
+				ix = i
+				break
+			}
+		}
+		out = append(out, (*ps)[ix])
+	}
+
+	return out
 }
 
 // shuffle a slice of pnts
@@ -68,17 +195,59 @@ func (ps *pnts) asgnCtr(c []cluster) []cluster {
 	ctrs := make(pnts, len(c))
 	for i, v := range c {
 		ctrs[i] = v.ctr
+		outCls[i].ctr = v.ctr
 	}
 
 	for _, v := range *ps {
 		_, ix := ctrs.nearest(v, true)
-		outCls[ix].ctr = ctrs[ix]
 		outCls[ix].cls = append(outCls[ix].cls, v)
 	}
 	return outCls
 
 }
 
+// re-seed empty clusters with the point farthest from its own center
+// taken from clusters that can spare one
+func fixEmpty(c []cluster) []cluster {
+	for i := range c {
+		if len(c[i].cls) > 0 {
+			continue
+		}
+
+		maxDist := -1.0
+		var fromCls, fromIx int
+		for j, v := range c {
+			if len(v.cls) < 2 {
+				continue
+			}
+			for k, loc := range v.cls {
+				if d := geoDist(v.ctr, loc); d > maxDist {
+					maxDist, fromCls, fromIx = d, j, k
+				}
+			}
+		}
+		if maxDist < 0 { // nothing to take
+			continue
+		}
+
+		c[i].cls = pnts{c[fromCls].cls[fromIx]}
+		c[i].ctr = c[fromCls].cls[fromIx]
+		c[fromCls].cls.rem(fromIx)
+	}
+	return c
+}
+
+// total dist of points to their cluster center (km)
+func clsDist(c []cluster) float64 {
+	var sum float64
+	for _, v := range c {
+		for _, loc := range v.cls {
+			sum += geoDist(v.ctr, loc)
+		}
+	}
+	return sum
+}
+
 func getCtrs(c []cluster) pnts {
 	out := make(pnts, len(c))
 	for i, v := range c {
@@ -87,9 +256,10 @@ func getCtrs(c []cluster) pnts {
 	return out
 }
 
-func compCtrs(preCtrs pnts, curCtrs pnts) bool {
+// true if no center moved more than tol (km)
+func compCtrs(preCtrs pnts, curCtrs pnts, tol float64) bool {
 	for i, v := range preCtrs {
-		if v != curCtrs[i] {
+		if geoDist(v, curCtrs[i]) > tol {
 			return false
 		}
 	}
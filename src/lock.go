@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// locked stops on a tour
+// positions are counted from home, the stop the route starts at (position 1)
+type lockSet struct {
+	home  point
+	n     int             // stops in the tour
+	at    map[point]int   // fixed stop to position, 0 based
+	slot  map[int]point   // position to fixed stop
+	seqs  []pnts          // stops visited back to back in file order
+	next  map[point]point // sequence stop to the one after it
+	size  map[point]int   // sequence head to its length
+	inner map[point]bool  // sequence stops after the head
+}
+
+// active locks, nil for none
+var lock *lockSet
+
+// locks from the lock column (label to value, see readCol)
+// first, last, a position k (1 is the start) or seq:<id>, stops sharing an id are visited in file order
+// a first stop replaces home as the start
+func newLock(ps pnts, home point, col map[point]string) (*lockSet, error) {
+	l := &lockSet{
+		home:  home,
+		n:     len(ps),
+		at:    make(map[point]int),
+		slot:  make(map[int]point),
+		next:  make(map[point]point),
+		size:  make(map[point]int),
+		inner: make(map[point]bool),
+	}
+
+	ids := make(map[string]int)
+	for _, p := range ps {
+		v := strings.ToLower(strings.TrimSpace(col[p]))
+		k := -1
+		switch {
+		case v == "":
+			continue
+		case v == "first":
+			k = 0
+		case v == "last":
+			k = len(ps) - 1
+		case strings.HasPrefix(v, "seq:"):
+			id := strings.TrimSpace(v[4:])
+			if id == "" {
+				return nil, fmt.Errorf("%s has a sequence with no id", p.lab)
+			}
+			if _, ok := ids[id]; !ok {
+				ids[id] = len(l.seqs)
+				l.seqs = append(l.seqs, nil)
+			}
+			l.seqs[ids[id]] = append(l.seqs[ids[id]], p)
+			continue
+		default:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s has lock %q, use first, last, a position or seq:<id>", p.lab, col[p])
+			}
+			if n < 1 || n > len(ps) {
+				return nil, fmt.Errorf("%s is fixed at position %d of %d stops", p.lab, n, len(ps))
+			}
+			k = n - 1
+		}
+		if q, ok := l.slot[k]; ok {
+			return nil, fmt.Errorf("%s and %s are both fixed at position %d", q.lab, p.lab, k+1)
+		}
+		l.slot[k] = p
+		l.at[p] = k
+	}
+
+	for id, i := range ids {
+		s := l.seqs[i]
+		if len(s) < 2 {
+			return nil, fmt.Errorf("sequence %q has one stop", id)
+		}
+		l.size[s[0]] = len(s)
+		for j := 1; j < len(s); j++ {
+			l.next[s[j-1]] = s[j]
+			l.inner[s[j]] = true
+		}
+	}
+
+	if p, ok := l.slot[0]; ok {
+		l.home = p
+	}
+	if k, ok := l.at[l.home]; ok && k != 0 {
+		return nil, fmt.Errorf("start %s is fixed at position %d, move the start", l.home.lab, k+1)
+	}
+	if l.inner[l.home] {
+		return nil, fmt.Errorf("start %s is inside a sequence, move the start", l.home.lab)
+	}
+	return l, nil
+}
+
+// true if the closed tour t keeps every lock
+func (l *lockSet) ok(t pnts) bool {
+	h := -1
+	for i, p := range t {
+		if p == l.home {
+			h = i
+			break
+		}
+	}
+	if h == -1 {
+		return false
+	}
+	for i, p := range t {
+		if k, ok := l.at[p]; ok && (i-h+len(t))%len(t) != k {
+			return false
+		}
+		if q, ok := l.next[p]; ok && t[(i+1)%len(t)] != q {
+			return false
+		}
+	}
+	return true
+}
+
+// stop that has to go at position k after from, false if any may
+func (l *lockSet) forced(from point, k int) (point, bool) {
+	if p, ok := l.slot[k]; ok {
+		return p, true
+	}
+	p, ok := l.next[from]
+	return p, ok
+}
+
+// true if p may go at position k, a sequence must fit before the next fixed stop
+func (l *lockSet) fits(p point, k int) bool {
+	if at, ok := l.at[p]; ok {
+		return at == k
+	}
+	if l.inner[p] {
+		return false
+	}
+	for i := k; i < k+l.size[p]; i++ {
+		if _, ok := l.slot[i]; ok || i >= l.n {
+			return false
+		}
+	}
+	return true
+}
+
+// fixed stops by position and the sequences, for display
+func (l *lockSet) String() string {
+	var ks []int
+	for k := range l.slot {
+		ks = append(ks, k)
+	}
+	sort.Ints(ks)
+	var out []string
+	for _, k := range ks {
+		out = append(out, fmt.Sprintf("%d:%s", k+1, l.slot[k].lab))
+	}
+	for _, s := range l.seqs {
+		var labs []string
+		for _, p := range s {
+			labs = append(labs, p.lab)
+		}
+		out = append(out, strings.Join(labs, ">"))
+	}
+	return strings.Join(out, " ")
+}
+
+// true if the tour keeps the precedence rules and locks
+func feasible(t pnts) bool {
+	return (prec == nil || prec.ok(t)) && (lock == nil || lock.ok(t))
+}
+
+// nearest stop in q to the end of the partial tour t that keeps the precedence rules and locks
+// locks count only when t starts at home, the nearest stop is used when none can follow
+func nextStop(q, t pnts, done map[point]bool) (point, int) {
+	from := t[len(t)-1]
+	lk := lock != nil && t[0] == lock.home
+	if lk {
+		if p, ok := lock.forced(from, len(t)); ok {
+			for i, v := range q {
+				if v == p {
+					return p, i
+				}
+			}
+		}
+	}
+
+	min := math.MaxFloat64
+	var best point
+	index := -1
+	for i, loc := range q {
+		if prec != nil && !prec.ready(loc, done) || lk && !lock.fits(loc, len(t)) {
+			continue
+		}
+		if h := cost(from, loc); h < min {
+			min, best, index = h, loc, i
+		}
+	}
+	if index == -1 {
+		return q.nearest(from, false)
+	}
+	return best, index
+}
@@ -6,11 +6,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"image/color"
 	"math"
 	"math/big"
 	"os"
 	"path/filepath"
-	"regexp"
 	"runtime/pprof"
 	"sort"
 	"strconv"
@@ -30,21 +30,94 @@ var (
 	meth       = flag.String("m", "auto", "opt method to use")
 	anchor     = flag.String("a", "", "pass anchor coords for rotation")
 	clusters   = flag.Int("cls", 0, "perform k-means clustering")
+	kRuns      = flag.Int("kruns", 1, "k-means restarts, best kept")
+	kIter      = flag.Int("kiter", 100, "k-means max iterations per run")
+	kTol       = flag.Float64("ktol", 0.001, "k-means center shift tolerance (km)")
+	cMin       = flag.Int("cmin", 0, "min stops per cluster (balanced)")
+	cMax       = flag.Int("cmax", 0, "max stops per cluster (balanced)")
+	demCol     = flag.String("dcol", "", "demand column name for balanced clustering")
+	demMax     = flag.Float64("dmax", 0, "max demand per cluster (balanced)")
+	clsMeth    = flag.String("cm", "kmeans", "clustering method to use")
+	eps        = flag.Float64("eps", 0.5, "dbscan neighbor radius (km)")
+	minPts     = flag.Int("minpts", 4, "dbscan min points for a dense region")
+	clsRoute   = flag.Bool("cr", false, "optimize a tour in each cluster")
+	ctrFile    = flag.String("ctrf", "centers.txt", "fixed centers file (label, lat, lon)")
+	drift      = flag.Bool("drift", false, "let fixed centers move to their stops")
+	radius     = flag.Float64("rad", 0, "max km from a stop to its fixed center")
+	link       = flag.String("link", "average", "hierarchical linkage")
+	hCut       = flag.Float64("hcut", 0, "hierarchical cut height (km)")
+	osmFile    = flag.String("osm", "", "OSM PBF extract for road distances")
+	metric     = flag.String("metric", "dist", "road cost to optimize: dist or time")
+	matFile    = flag.String("mat", "", "stop cost matrix file (tsv, csv or json)")
+	engURL     = flag.String("eng", "", "routing engine url for road costs (eg. http://localhost:5000)")
+	engKind    = flag.String("etype", "osrm", "routing engine api: osrm or valhalla")
+	engProf    = flag.String("eprof", "", "engine profile or costing (default driving or auto)")
+	engChunk   = flag.Int("echunk", 50, "max stops per side of an engine request")
+	engCache   = flag.String("ecache", "tsscache", "engine response cache dir, blank for none")
+	matOut     = flag.String("matx", "", "export straight line matrix to file and quit")
 	format     = flag.Bool("fmt", true, "format output with headers and order")
+	hdrRows    = flag.Bool("hdr", false, "add the stop count and a tour: length row to the header of the -o file")
+	extCols    = flag.String("xcols", "", "extra formatted route columns, comma separated: leg, cum, time, bear")
 	centers    = flag.Bool("ctr", false, "process centroids not locations")
+	budget     = flag.Float64("bud", 0, "max round trip length (km or matrix unit), visits the best subset")
+	budHours   = flag.Float64("budh", 0, "max round trip hours at -kph, visits the best subset")
+	speed      = flag.Float64("kph", 40, "average speed for -budh and the time column (km/h)")
+	scoreCol   = flag.String("scol", "", "stop score column name for -bud/-budh, default 1 per stop")
+	pairCol    = flag.String("pcol", "", "pickup/delivery pair id column, the first stop in the file is the pickup")
+	afterCol   = flag.String("acol", "", "column of labels (; separated) a stop must come after")
+	lockCol    = flag.String("lcol", "", "lock column: first, last, a position or seq:<id> for stops visited back to back")
+	insFile    = flag.String("ins", "", "published route file (out.txt) to insert the input's new stops into")
+	insRep     = flag.Int("irep", 0, "2-Opt repair window (stops) around inserted stops, 0 keeps the published order")
+	prevFile   = flag.String("prev", "", "previous route file (out.txt) to re-optimize against, stops matched by label")
+	prevW      = flag.Float64("pw", 1, "with -prev, cost per leg not in the previous route (km or matrix unit)")
+	evalMode   = flag.Bool("eval", false, "measure the input order as given: legs, crossings and the gap to 2-Opt")
+	dayStops   = flag.Int("dstops", 0, "split the route into days of at most this many stops")
+	dayKm      = flag.Float64("dkm", 0, "split the route into days of at most this length (km or matrix unit)")
+	dayHrs     = flag.Float64("dhrs", 0, "split the route into days of at most these hours, driving at -kph plus -svc")
+	service    = flag.Float64("svc", 0, "service minutes per stop for -dhrs")
+	dayBase    = flag.String("base", "", "base coords each day leaves from and returns to")
+	objective  = flag.String("obj", "sum", "objective: sum (length), bottle (longest leg) or minmax (longest crew route)")
+	crews      = flag.Int("crews", 2, "crews for -obj minmax, each route leaves from the start stop")
+	mapW       = flag.Int("mapw", 800, "map image width (px)")
+	mapH       = flag.Int("maph", 600, "map image height (px)")
+	mkSize     = flag.Float64("mks", 14, "route map marker size")
+	mapClrs    = flag.String("mclr", "ff3333,9b33ff,33cc33,333333", "route map colors (hex): stops, path, start, end")
+	mapLab     = flag.Bool("mlab", false, "show stop labels after the visit number on route maps")
+	mapFmt     = flag.String("mfmt", "png", "map file formats, comma separated: png, svg, html")
+	leafDir    = flag.String("leaflet", "", "dir holding a leaflet.js and leaflet.css to inline in html maps instead of the built in copy")
+	ptsMap     = flag.Bool("pmap", false, "also map the input stops and their center, numbered in file order")
+	tilePath   = flag.String("tiles", "", "draw maps from local tiles, a z/x/y.png dir or .mbtiles file, no downloads")
+	noBase     = flag.Bool("nobase", false, "draw maps on a blank canvas, no basemap")
+	preBox     = flag.String("prefetch", "", "download tiles for a box (lat1,lon1,lat2,lon2) into the -tiles dir and quit")
+	preZoom    = flag.String("pzoom", "10-16", "zoom range for -prefetch")
+	tileURL    = flag.String("turl", "https://tile.openstreetmap.org/{z}/{x}/{y}.png", "tile url for -prefetch and html maps")
+	fleetFile  = flag.String("fleet", "", "fleet file (depot, lat, lon, vehicle, cap, maxkm, perkm), route stops across vehicles")
+	distModel  = flag.String("dm", "haver", "distance model: haver, sphere, vincenty, karney or fast")
 	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 )
 
+// available clustering methods
+var methCLS = []string{"kmeans", "dbscan", "fixed", "hier"}
+
+// available extra route columns, and those picked by -xcols
+var legCols = []string{"leg", "cum", "time", "bear"}
+var outCols []string
+
+// methods built on 2-Opt segment reversals
+var opt2Meth = map[string]bool{"opt": true, "resOpt": true, "bigOpt": true}
+
 // available methods in order of quality (0 is best)
 var methOPT = map[int]string{
 	-1: "auto",
 	0:  "exh",
-	1:  "opt",
-	2:  "resOpt",
-	3:  "bigOpt",
-	4:  "nnMul",
-	5:  "nn",
-	6:  "none",
+	1:  "hk",
+	2:  "opt",
+	3:  "atsp",
+	4:  "resOpt",
+	5:  "bigOpt",
+	6:  "nnMul",
+	7:  "nn",
+	8:  "none",
 }
 
 func main() {
@@ -58,6 +131,61 @@ func main() {
 		return
 	}
 
+	// check clustering method flag
+	if !inCls(*clsMeth) {
+		fmt.Printf("%q is not a valid clustering method\n", *clsMeth)
+		fmt.Printf("valid methods: %s\n", strings.Join(methCLS, ", "))
+		return
+	}
+
+	// check k-means flags
+	if *kRuns < 1 || *kIter < 1 {
+		fmt.Println("error, -kruns and -kiter must be at least 1")
+		return
+	}
+
+	// check distance model flag
+	if !inDist(*distModel) {
+		fmt.Printf("%q is not a valid distance model\n", *distModel)
+		fmt.Printf("valid models: %s\n", strings.Join(distMods, ", "))
+		return
+	}
+	distMod = *distModel
+
+	// map style flags
+	if err := setStyle(); err != nil {
+		fmt.Printf("error, %v\n", err)
+		return
+	}
+
+	// check objective flag
+	if !inObj(*objective) {
+		fmt.Printf("%q is not a valid objective\n", *objective)
+		fmt.Printf("valid objectives: %s\n", strings.Join(objKinds, ", "))
+		return
+	}
+	if *objective == "minmax" && *crews < 2 {
+		fmt.Println("error, -obj minmax needs at least 2 crews")
+		return
+	}
+
+	// check extra column flag
+	for _, v := range strings.Split(*extCols, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		ok := false
+		for _, c := range legCols {
+			ok = ok || v == c
+		}
+		if !ok {
+			fmt.Printf("%q is not a valid column\n", v)
+			fmt.Printf("valid columns: %s\n", strings.Join(legCols, ", "))
+			return
+		}
+		outCols = append(outCols, v)
+	}
+
 	// profiling start
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -74,9 +202,19 @@ func main() {
 		fmt.Printf("error getting current directory: %v\n", err)
 	}
 
+	// tile prefetch interupt
+	if *preBox != "" {
+		methodPrefetch(dir)
+		return
+	}
+
 	// load and check file
 	fmt.Println("loading file...")
-	p, err := readFile(filepath.Join(dir, *inFile))
+	read := readFile
+	if *evalMode {
+		read = readOrder // no dedup, the order is measured as given
+	}
+	p, err := read(filepath.Join(dir, *inFile))
 	if err != nil {
 		fmt.Printf("error loading file: %v\n", err)
 	}
@@ -89,37 +227,27 @@ func main() {
 	}
 
 	// clustering interupt
-	if *clusters > 0 {
-
-		if *clusters >= len(p) {
-			rVal := int(math.Pow(float64(len(p)), 0.33))
-			fmt.Printf("error, %d nodes and asked for %d clusters\n", len(p), *clusters)
-			fmt.Printf("choose a number < %d (recommended:%d)\n", len(p), rVal)
-			return
-		}
-
-		fmt.Printf("finding %d clusters\n", *clusters)
-		clsRes := p.kmeans(*clusters)
-		clsPath := filepath.Join(dir, "clusters")
-		if _, err := os.Stat(clsPath); os.IsNotExist(err) {
-			os.Mkdir(clsPath, os.ModeDir)
-		}
-
-		// remove any output files already in directory
-		if err := remFiles(clsPath); err != nil {
-			fmt.Printf("error, could not remove files in clusters dir %v\n", err)
-		}
+	if *clusters > 0 || *clsMeth != "kmeans" {
+		methodCls(p, dir)
+		return // don't perform routing if clustering is selected
+	}
 
-		for i, v := range clsRes {
-			clsCtr, clsDist := v.cls.centPnt()
-			writeFile(v.cls, clsCtr, clsDist, filepath.Join(clsPath, "cls"+strconv.Itoa(i)+".txt"), *format)
-		}
+	// multi-depot fleet interupt
+	if *fleetFile != "" {
+		methodFleet(p, dir)
+		return
+	}
 
-		fmt.Println("generating cluster map..")
-		genClusters(clsRes, filepath.Join(clsPath, "clusters"))
+	// insert into a published route interupt
+	if *insFile != "" {
+		methodIns(p, dir)
+		return
+	}
 
-		fmt.Println("skipping routing")
-		return // don't perform routing if clustering is selected
+	// re-optimize against a previous route interupt
+	if *prevFile != "" {
+		methodReplan(p, dir)
+		return
 	}
 
 	// convert data to centroids
@@ -131,6 +259,28 @@ func main() {
 
 	}
 
+	// export matrix interupt
+	if *matOut != "" {
+		if err := writeMatrix(filepath.Join(dir, *matOut), p); err != nil {
+			fmt.Printf("error writing matrix: %v\n", err)
+			return
+		}
+		fmt.Printf("wrote %dx%d matrix to %v\n", len(p), len(p), *matOut)
+		return
+	}
+
+	// road network or provided costs
+	if err := setMatrix(p, dir); err != nil {
+		fmt.Printf("error building cost matrix: %v\n", err)
+		return
+	}
+
+	// evaluate the given order interupt
+	if *evalMode {
+		methodEval(p, dir)
+		return
+	}
+
 	// process anchor flag
 	if *anchor != "" {
 		aPnt, err := anchToPnt(*anchor)
@@ -144,14 +294,90 @@ func main() {
 		fmt.Printf("using provided anchor:%v, at node:%d,%v\n", aPnt, newStart+1, pNear)
 	}
 
+	// locked stops, counted from the start stop, a first stop moves the start
+	if *lockCol != "" {
+		if *budget > 0 || *budHours > 0 {
+			fmt.Println("error, route budget can not be combined with locks")
+			return
+		}
+		if err := setLock(p, p[*start], dir); err != nil {
+			fmt.Printf("error reading locks: %v\n", err)
+			return
+		}
+		for i, pnt := range p {
+			if pnt == lock.home && i != *start {
+				*start = i
+				fmt.Printf("locked first stop at node %d, using as start\n", i+1)
+			}
+		}
+		fmt.Printf("locks: %v\n", lock)
+	}
+
+	// precedence rules, counted from the start stop
+	if *pairCol != "" || *afterCol != "" {
+		if *budget > 0 || *budHours > 0 {
+			fmt.Println("error, route budget can not be combined with precedence rules")
+			return
+		}
+		if err := setPrec(p, p[*start], dir); err != nil {
+			fmt.Printf("error reading precedence rules: %v\n", err)
+			return
+		}
+		fmt.Printf("%d precedence rules, route starts at node %d\n", len(prec.cons), *start+1)
+	}
+	constr := prec != nil || lock != nil
+	if constr && (*meth == "hk" || *meth == "atsp" || *meth == "nnMul") {
+		fmt.Printf("%s does not check precedence or locks, using opt\n", *meth)
+		*meth = "opt"
+	}
+
+	// objective, minmax crews leave from the start stop
+	if *objective != "sum" {
+		if *budget > 0 || *budHours > 0 {
+			fmt.Println("error, route budget can not be combined with -obj")
+			return
+		}
+		objKind, objCrews, objHome = *objective, *crews, p[*start]
+		if *meth == "hk" || *meth == "atsp" {
+			fmt.Printf("%s only minimizes the sum, using opt\n", *meth)
+			*meth = "opt"
+		}
+	}
+
 	// choose method
 	cnt := len(p)
 	out := make(pnts, len(p))
 	optDone := true
 
+	// directed costs, 2-opt reversals change the cost of the reversed legs
+	asym := p.isAsym()
+	if asym {
+		fmt.Println("costs are asymmetric (a->b != b->a)")
+		if opt2Meth[*meth] {
+			fmt.Println("warning, 2-Opt reverses segments, -m atsp or hk keep direction")
+		}
+	}
+
+	// budget, route a subset
+	var skip pnts
+	orient := *budget > 0 || *budHours > 0
+
+	// feasible start for 2-Opt, input order may break precedence or locks
+	seed := p
+	if constr {
+		seed = p.nna(*start)
+	}
+
 	s1 := time.Now()
 
 	switch {
+	case orient:
+		var err error
+		out, skip, err = methodOrient(p, *start, dir)
+		if err != nil {
+			fmt.Printf("error choosing stops: %v\n", err)
+			return
+		}
 	case cnt < 4 || *meth == "none":
 		fmt.Println("nothing to optimize")
 		optDone = false
@@ -163,7 +389,7 @@ func main() {
 			return
 		}
 	case *meth == "opt":
-		out = methodOpt(p, *rate, false, -1, true)
+		out = methodOpt(seed, *rate, false, -1, true)
 	case *meth == "resOpt":
 		out = methodOpt(p.nna(*start), *rate, true, -1, true)
 	case *meth == "bigOpt":
@@ -172,12 +398,24 @@ func main() {
 		out = methodNN(p, *start, false)
 	case *meth == "nnMul":
 		out = methodNN(p, *start, true)
+	case *meth == "hk":
+		quit := true
+		out, quit = methodHK(p)
+		if quit {
+			return
+		}
+	case *meth == "atsp":
+		out = methodATSP(p.nna(*start))
 
 	// auto
 	case cnt < 11:
 		out, _ = methodExh(p)
+	case asym && !constr && objKind == "sum" && cnt <= maxHK:
+		out, _ = methodHK(p)
+	case asym && !constr && objKind == "sum":
+		out = methodATSP(p.nna(*start))
 	case cnt <= 750: //max 7min
-		out = methodOpt(p, *rate, false, -1, true)
+		out = methodOpt(seed, *rate, false, -1, true)
 	case cnt <= 3000: //max 8min
 		out = methodOpt(p.nna(*start), *rate, true, -1, true)
 	case cnt <= 10000:
@@ -206,7 +444,33 @@ func main() {
 	out.rotIn(ix)
 
 	if optDone {
-		fmt.Printf("final tour length: %.4f km\n", out.tourLen())
+		fmt.Printf("final tour length: %.4f %s\n", out.tourLen(), costUnit())
+	}
+	switch objKind {
+	case "bottle":
+		l, i := out.maxLeg()
+		fmt.Printf("longest leg: %.4f %s, %s to %s\n", l, costUnit(), out[i].lab, out[(i+1)%len(out)].lab)
+	case "minmax":
+		rts, l := out.crewSplit(objHome, objCrews)
+		fmt.Printf("longest crew route: %.4f %s over %d crews\n", l, costUnit(), len(rts))
+		if err := writeCrews(rts, dir); err != nil {
+			fmt.Printf("error writing file: %v\n", err)
+			return
+		}
+	}
+
+	rName := outName()
+	if lock != nil && !lock.ok(out) {
+		fmt.Println("warning, route breaks locks")
+	}
+	if prec != nil {
+		if !prec.ok(out) {
+			fmt.Println("warning, route breaks precedence rules")
+		}
+		if err := writePrec(out, filepath.Join(dir, rName+"_prec.txt")); err != nil {
+			fmt.Printf("error writing file: %v\n", err)
+			return
+		}
 	}
 	fmt.Printf("writing results to %v\n", *outFile)
 
@@ -215,18 +479,39 @@ func main() {
 	ctr, ctrDist := out.centPnt()
 	fmt.Printf("{%.6f,%.6f}\t%.2fkm avg dist\n", ctr.lat, ctr.lon, ctrDist/float64(len(p)))
 
-	if err := writeFile(out, ctr, ctrDist, filepath.Join(dir, *outFile), *format); err != nil {
+	if err := writeFile(out, ctr, ctrDist, filepath.Join(dir, *outFile), *format, true, hdrLen(out)); err != nil {
 		fmt.Printf("error writing file: %v\n", err)
 		return
 	}
 
+	if orient {
+		skName := rName + "_skipped.txt"
+		os.Remove(filepath.Join(dir, skName))
+		if len(skip) > 0 {
+			fmt.Printf("writing skipped stops to %v\n", skName)
+			skCtr, skDist := skip.centPnt()
+			if err := writeFile(skip, skCtr, skDist, filepath.Join(dir, skName), *format, false, 0); err != nil {
+				fmt.Printf("error writing file: %v\n", err)
+				return
+			}
+		}
+	}
+
 	if *img {
-		rName := (*outFile)[:strings.Index(*outFile, ".txt")]
 		fmt.Println("generating route and center plot")
 		if err := genRoute(out, ctr, rName+"_route"); err != nil {
 			fmt.Printf("error building route: %v\n", err)
-			return
 		}
+		if *ptsMap {
+			if err := genPoints(p, ctr, rName+"_points"); err != nil {
+				fmt.Printf("error building points map: %v\n", err)
+			}
+		}
+	}
+
+	// split into days
+	if *dayStops > 0 || *dayKm > 0 || *dayHrs > 0 {
+		methodDays(out, dir)
 	}
 
 }
@@ -282,126 +567,1142 @@ func methodOpt(p pnts, r float64, b bool, lim int, sa bool) pnts {
 	return p.opt2SA(r, b, lim, sa)
 }
 
-func methodNN(p pnts, s int, m bool) pnts {
+// clustering selected by cm flag, write a file per cluster and a map
+func methodCls(p pnts, dir string) {
 
-	if m {
-		fmt.Println("using multi-start nearest neighbor")
-		return p.nnaMul()
-	}
+	var clsRes []cluster
+	var noise pnts
+	nseName := "noise.txt"
 
-	fmt.Printf("using nearest neighbor, starting at node: %d\n", s+1)
-	return p.nna(s)
+	switch *clsMeth {
+	case "kmeans":
+		if *clusters >= len(p) {
+			rVal := int(math.Pow(float64(len(p)), 0.33))
+			fmt.Printf("error, %d nodes and asked for %d clusters\n", len(p), *clusters)
+			fmt.Printf("choose a number < %d (recommended:%d)\n", len(p), rVal)
+			return
+		}
 
-}
+		if *cMin > 0 || *cMax > 0 || *demMax > 0 {
+			lim, err := readLim(dir)
+			if err != nil {
+				fmt.Printf("error reading demand column: %v\n", err)
+				return
+			}
+			if err := lim.check(p, *clusters); err != nil {
+				fmt.Printf("error, %v\n", err)
+				return
+			}
 
-// file processing
-func readFile(path string) (pnts, error) {
+			fmt.Printf("finding %d balanced clusters\n", *clusters)
+			clsRes = p.balKmeans(*clusters, *kRuns, *kIter, *kTol, lim)
+			if ov := lim.over(clsRes); len(ov) > 0 {
+				fmt.Printf("warning, clusters %v are outside the limits\n", ov)
+			}
+			for i, v := range clsRes {
+				if lim.dem != nil {
+					fmt.Printf("cluster %d: %d stops, %.2f demand\n", i, len(v.cls), lim.load(v.cls))
+				} else {
+					fmt.Printf("cluster %d: %d stops\n", i, len(v.cls))
+				}
+			}
+		} else {
+			fmt.Printf("finding %d clusters\n", *clusters)
+			clsRes = p.kmeans(*clusters, *kRuns, *kIter, *kTol)
+		}
 
-	csvFile, err := os.Open(path)
-	if err != nil {
-		return pnts{}, err
-	}
-	defer csvFile.Close()
+	case "fixed":
+		ctrs, err := readFile(filepath.Join(dir, *ctrFile))
+		if err != nil {
+			fmt.Printf("error loading centers file: %v\n", err)
+			return
+		}
+		if len(ctrs) == 0 {
+			fmt.Println("error, no centers in", *ctrFile)
+			return
+		}
 
-	reader := csv.NewReader(csvFile)
+		lim, err := readLim(dir)
+		if err != nil {
+			fmt.Printf("error reading demand column: %v\n", err)
+			return
+		}
+		lim.rad = *radius
+		if err := lim.check(p, len(ctrs)); err != nil {
+			fmt.Printf("error, %v\n", err)
+			return
+		}
 
-	// configure csv reader
-	reader.Comma = '\t' // set split token
+		str := "pinned"
+		if *drift {
+			str = "drifting"
+		}
+		fmt.Printf("assigning stops to %d %s centers\n", len(ctrs), str)
+		clsRes, noise = p.fixedCls(ctrs, *drift, *kIter, *kTol, lim)
+		nseName = "unassigned.txt"
+		if ov := lim.over(clsRes); len(ov) > 0 {
+			fmt.Printf("warning, centers %v are outside the limits\n", ov)
+		}
+		for _, v := range clsRes {
+			fmt.Printf("%s: %d stops\n", v.ctr.lab, len(v.cls))
+		}
+		if len(noise) > 0 {
+			fmt.Printf("%d stops out of reach of any center\n", len(noise))
+		}
 
-	records, err := reader.ReadAll() // -> [][]string
-	if err != nil {
-		return pnts{}, err
+	case "hier":
+		if !inLink(*link) {
+			fmt.Printf("%q is not a valid linkage\n", *link)
+			fmt.Printf("valid linkages: %s\n", strings.Join(linkHC, ", "))
+			return
+		}
+		if *hCut <= 0 && (*clusters < 1 || *clusters >= len(p)) {
+			fmt.Printf("error, hier needs -hcut or -cls between 1 and %d\n", len(p)-1)
+			return
+		}
+
+		if *hCut > 0 {
+			fmt.Printf("finding %s linkage clusters cut at %.3fkm\n", *link, *hCut)
+		} else {
+			fmt.Printf("finding %d %s linkage clusters\n", *clusters, *link)
+		}
+		clsRes = p.hclust(*link, *clusters, *hCut)
+		fmt.Printf("found %d clusters\n", len(clsRes))
+
+	case "dbscan":
+		if *eps <= 0 || *minPts < 1 {
+			fmt.Println("error, dbscan needs eps > 0 and minpts >= 1")
+			return
+		}
+
+		fmt.Printf("finding dense clusters, eps:%.3fkm minpts:%d\n", *eps, *minPts)
+		clsRes, noise = p.dbscan(*eps, *minPts)
+		fmt.Printf("found %d clusters and %d noise stops\n", len(clsRes), len(noise))
+		if len(clsRes) == 0 {
+			fmt.Println("no clusters found, try a larger eps or smaller minpts")
+			return
+		}
 	}
-	if len(records) == 0 {
-		return pnts{}, errors.New("empty input file")
+
+	clsPath := filepath.Join(dir, "clusters")
+	if _, err := os.Stat(clsPath); os.IsNotExist(err) {
+		os.Mkdir(clsPath, os.ModeDir)
 	}
-	records = records[1:] // drop header record
 
-	// check for empty LatLon
-	if err := checkEmp(records); err != nil {
-		return pnts{}, err
+	// remove any output files already in directory
+	if err := remFiles(clsPath); err != nil {
+		fmt.Printf("error, could not remove files in clusters dir %v\n", err)
 	}
 
-	p := make(pnts, len(records))
+	if *clsRoute {
+		var depot *point
+		if *anchor != "" {
+			aPnt, err := anchToPnt(*anchor)
+			if err != nil {
+				fmt.Printf("error, could not parse %q: %v\n", *anchor, err)
+				return
+			}
+			depot = &aPnt
+			fmt.Printf("starting cluster tours nearest depot:%v\n", aPnt)
+		}
 
-	for i, rec := range records {
-		p[i].lab = strings.TrimSpace(rec[0])
-		p[i].lat, err = strconv.ParseFloat(rec[1], 64)
-		if err != nil {
-			return pnts{}, err
+		if err := setMatrix(p, dir); err != nil {
+			fmt.Printf("error building cost matrix: %v\n", err)
+			return
 		}
-		p[i].lon, err = strconv.ParseFloat(rec[2], 64)
-		if err != nil {
-			return pnts{}, err
+
+		fmt.Printf("routing %d clusters..\n", len(clsRes))
+		s1 := time.Now()
+		clsRes = routeCls(clsRes, depot, *rate)
+		fmt.Println("optimization took:", time.Since(s1))
+
+		var tot float64
+		for i, v := range clsRes {
+			tl := v.cls.tourLen()
+			tot += tl
+			fmt.Printf("cluster %d: %d stops, %.4f %s\n", i, len(v.cls), tl, costUnit())
 		}
+		fmt.Printf("total of cluster tours: %.4f %s\n", tot, costUnit())
 	}
 
-	// check records
-	p, err = checkRec(p)
-	if err != nil {
-		return pnts{}, err
+	names := clsNames(clsRes, *clsMeth == "fixed")
+	for i, v := range clsRes {
+		if len(v.cls) == 0 {
+			fmt.Printf("skipping %s, no stops\n", names[i])
+			continue
+		}
+		clsCtr, clsDist := v.cls.centPnt()
+		writeFile(v.cls, clsCtr, clsDist, filepath.Join(clsPath, names[i]), *format, *clsRoute, v.cls.tourLen())
 	}
 
-	return p, nil
-}
+	if len(noise) > 0 {
+		nseCtr, nseDist := noise.centPnt()
+		writeFile(noise, nseCtr, nseDist, filepath.Join(clsPath, nseName), *format, false, 0)
+	}
 
-func checkEmp(recs [][]string) error {
-	for i, rec := range recs {
-		if strings.TrimSpace(rec[1]) == "" || strings.TrimSpace(rec[2]) == "" {
-			return errors.New("missing LatLon! row: " + strconv.Itoa(i+2))
+	fmt.Println("generating cluster map..")
+	genClusters(clsRes, noise, filepath.Join(clsPath, "clusters"))
+
+	if *clsRoute {
+		fmt.Println("generating cluster route map..")
+		if err := genClsRoutes(clsRes, filepath.Join(clsPath, "routes")); err != nil {
+			fmt.Printf("error building routes: %v\n", err)
 		}
+		return
 	}
-	return nil
-}
 
-func checkRec(recs pnts) (pnts, error) {
+	fmt.Println("skipping routing")
+}
 
-	// check populated
-	for i, rec := range recs {
-		if rec.lab == "" {
-			return pnts{}, errors.New("unpopulated record! row: " + strconv.Itoa(i+2) + " column: label")
+// build the stop cost matrix from the flags, leaves straight lines in place if none given
+func setMatrix(p pnts, dir string) error {
+	if *osmFile == "" && *matFile == "" && *engURL == "" {
+		// ellipsoid models are slow, compute each pair once
+		if (distMod == "vincenty" || distMod == "karney") && len(p) <= maxGeoMat {
+			mat = geoMatrix(p)
 		}
-		if rec.lat == 0 {
-			return pnts{}, errors.New("unpopulated record! row: " + strconv.Itoa(i+2) + " column: lat")
+		return nil
+	}
+	if *metric != "dist" && *metric != "time" {
+		return fmt.Errorf("%q is not a valid metric (dist, time)", *metric)
+	}
+
+	// day base legs are matrix costs too
+	if *dayStops > 0 || *dayKm > 0 || *dayHrs > 0 {
+		b, err := dayBasePnt()
+		if err != nil {
+			return err
 		}
-		if rec.lon == 0 {
-			return pnts{}, errors.New("unpopulated record! row: " + strconv.Itoa(i+2) + " column: lon")
+		if b != nil {
+			p = append(append(pnts{}, p...), *b)
 		}
 	}
 
-	// remove dups
-	distVals := make(map[point]struct{})
-	dups := []int{}
-
-	tmp := pnts{}
-	for i, rec := range recs {
-		if _, ok := distVals[rec]; !ok {
-			distVals[rec] = struct{}{}
-			tmp = append(tmp, rec)
-		} else {
-			dups = append(dups, i+2)
+	if *matFile != "" {
+		fmt.Printf("loading %s matrix %v..\n", *metric, *matFile)
+		m, err := readMatrix(filepath.Join(dir, *matFile), p, *metric)
+		if err != nil {
+			if *dayBase != "" {
+				return fmt.Errorf("%v, with -base the matrix file needs a base row and column", err)
+			}
+			return err
 		}
+		mat = m
+		return nil
 	}
 
-	if len(recs)-len(tmp) == 1 {
-		fmt.Printf("removed %d duplicate at row:%v\n", len(recs)-len(tmp), dups)
-	} else if len(recs)-len(tmp) > 1 {
-		fmt.Printf("removed %d duplicates at rows:%v\n", len(recs)-len(tmp), dups)
-	}
+	if *engURL != "" {
+		cache := ""
+		if *engCache != "" {
+			cache = filepath.Join(dir, *engCache)
+		}
+		e, err := newEngine(*engURL, *engKind, *engProf, *engChunk, cache)
+		if err != nil {
+			return err
+		}
 
-	// check valid coords
-	for i, rec := range tmp {
-		chk := s2.LatLngFromDegrees(rec.lat, rec.lon)
-		if !chk.IsValid() {
-			return pnts{}, errors.New("invalid LatLng, row: " + strconv.Itoa(i+2))
+		fmt.Printf("requesting %s matrix from %s..\n", *metric, e.url)
+		s1 := time.Now()
+		m, err := e.matrix(p, *metric)
+		if err != nil {
+			fmt.Printf("warning, routing engine failed (%v), using straight line\n", err)
+			return nil
 		}
+		mat = m
+		fmt.Printf("engine %s matrix for %d stops took: %v\n", *metric, len(p), time.Since(s1))
+		return nil
 	}
 
-	return tmp, nil
-}
+	fmt.Printf("loading road network %v..\n", *osmFile)
+	s1 := time.Now()
+	g, err := loadOSM(filepath.Join(dir, *osmFile))
+	if err != nil {
+		return err
+	}
+	mat = g.matrix(p, *metric)
+	fmt.Printf("road %s matrix for %d stops took: %v\n", *metric, len(p), time.Since(s1))
 
-func writeFile(p pnts, c point, d float64, dest string, format bool) error {
-	tour := make([][]string, len(p))
+	return nil
+}
+
+// route the stops over the vehicles in the fleet file, one output per vehicle
+func methodFleet(p pnts, dir string) {
+	vs, err := readFleet(filepath.Join(dir, *fleetFile))
+	if err != nil {
+		fmt.Printf("error loading fleet file: %v\n", err)
+		return
+	}
+
+	var dem map[point]float64
+	if *demCol != "" {
+		cv, err := readCol(filepath.Join(dir, *inFile), *demCol)
+		if err == nil {
+			dem, err = colFloat(cv)
+		}
+		if err != nil {
+			fmt.Printf("error reading demand column: %v\n", err)
+			return
+		}
+	}
+
+	// depots join the stops in the matrix, a matrix file must hold them too (by label)
+	// or depot legs would fall back to straight km next to matrix costs
+	all := append(pnts{}, p...)
+	in := make(map[point]bool)
+	for _, v := range p {
+		in[v] = true
+	}
+	for _, v := range vs {
+		if !in[v.depot] {
+			in[v.depot] = true
+			all = append(all, v.depot)
+		}
+	}
+	if err := setMatrix(all, dir); err != nil {
+		fmt.Printf("error building cost matrix: %v\n", err)
+		if *matFile != "" {
+			fmt.Println("with -fleet the matrix file needs a row and column for each depot as well as the stops")
+		}
+		return
+	}
+
+	fmt.Printf("routing %d stops over %d vehicles..\n", len(p), len(vs))
+	s1 := time.Now()
+	rts, left := p.fleetRoute(vs, dem, *rate)
+	fmt.Println("optimization took:", time.Since(s1))
+
+	flPath := filepath.Join(dir, "fleet")
+	if _, err := os.Stat(flPath); os.IsNotExist(err) {
+		os.Mkdir(flPath, os.ModeDir)
+	}
+	old, _ := filepath.Glob(filepath.Join(flPath, "*.txt"))
+	for _, f := range old {
+		os.Remove(f)
+	}
+
+	var tot float64
+	c := make([]cluster, len(rts))
+	nms := make([]string, len(rts))
+	for i, r := range rts {
+		nms[i] = fileName(r.v.lab)
+	}
+	nms = txtNames(nms)
+	for i, r := range rts {
+		t := r.tour()
+		tot += r.cost()
+		fmt.Printf("%s (%s): %d stops, load %.2f, %.4f %s, cost %.2f\n", r.v.lab, r.v.depot.lab, len(r.stops), r.load, t.tourLen(), costUnit(), r.cost())
+
+		c[i] = cluster{ctr: r.v.depot, cls: t}
+		nm := filepath.Join(flPath, nms[i])
+		if len(r.stops) == 0 {
+			continue
+		}
+		ctr, ctrDist := r.stops.centPnt()
+		if err := writeFile(t, ctr, ctrDist, nm, *format, true, t.tourLen()); err != nil {
+			fmt.Printf("error writing file: %v\n", err)
+			return
+		}
+	}
+	fmt.Printf("total fleet cost: %.2f\n", tot)
+
+	if len(left) > 0 {
+		fmt.Printf("%d stops fit no vehicle, writing unassigned.txt\n", len(left))
+		ctr, ctrDist := left.centPnt()
+		writeFile(left, ctr, ctrDist, filepath.Join(flPath, "unassigned.txt"), *format, false, 0)
+	}
+
+	if *img {
+		fmt.Println("generating fleet route map..")
+		if err := genClsRoutes(c, filepath.Join(flPath, "routes")); err != nil {
+			fmt.Printf("error building routes: %v\n", err)
+		}
+	}
+}
+
+// insert the stops not in the published route at their cheapest positions
+func methodIns(p pnts, dir string) {
+	t, err := readRoute(filepath.Join(dir, *insFile))
+	if err != nil {
+		fmt.Printf("error loading route: %v\n", err)
+		return
+	}
+
+	// new stops matched by label
+	have := make(map[string]bool, len(t))
+	for _, v := range t {
+		have[v.lab] = true
+	}
+	var add pnts
+	for _, v := range p {
+		if !have[v.lab] {
+			add = append(add, v)
+		}
+	}
+	if len(add) == 0 {
+		fmt.Printf("every stop is in %v, nothing to insert\n", *insFile)
+		return
+	}
+
+	if err := setMatrix(append(append(pnts{}, t...), add...), dir); err != nil {
+		fmt.Printf("error building cost matrix: %v\n", err)
+		return
+	}
+
+	base := t.tourLen()
+	fmt.Printf("inserting %d stops into %d stop route of %.4f %s\n", len(add), len(t), base, costUnit())
+	out, _ := insStops(t, add)
+	if *insRep > 0 {
+		ins := make(map[point]bool, len(add))
+		for _, v := range add {
+			ins[v] = true
+		}
+		fmt.Printf("repairing %d stops around each insert\n", *insRep)
+		out = repair(out, ins, *insRep)
+	}
+
+	rows := insRows(out, add)
+	for _, r := range rows[1:] {
+		fmt.Printf("%s: stop %s between %s and %s, +%s %s\n", r[0], r[1], r[2], r[3], r[4], costUnit())
+	}
+	fmt.Printf("final tour length: %.4f %s (+%.4f)\n", out.tourLen(), costUnit(), out.tourLen()-base)
+
+	rName := outName()
+	fmt.Printf("writing results to %v and %v\n", *outFile, rName+"_ins.txt")
+	ctr, ctrDist := out.centPnt()
+	if err := writeFile(out, ctr, ctrDist, filepath.Join(dir, *outFile), *format, true, hdrLen(out)); err != nil {
+		fmt.Printf("error writing file: %v\n", err)
+		return
+	}
+	if err := writeRows(rows, filepath.Join(dir, rName+"_ins.txt")); err != nil {
+		fmt.Printf("error writing file: %v\n", err)
+		return
+	}
+
+	if *img {
+		fmt.Println("generating route and center plot")
+		if err := genRoute(out, ctr, rName+"_route"); err != nil {
+			fmt.Printf("error building route: %v\n", err)
+		}
+	}
+}
+
+// re-optimize with a cost for each leg that differs from the previous route, write the stops that moved
+func methodReplan(p pnts, dir string) {
+	prev, err := readRoute(filepath.Join(dir, *prevFile))
+	if err != nil {
+		fmt.Printf("error loading route: %v\n", err)
+		return
+	}
+	r := newReplan(prev, p)
+	fmt.Printf("previous route %d stops: %d kept, %d removed, %d added\n", len(prev), len(r.old), len(r.gone), len(r.added))
+
+	if err := setMatrix(p, dir); err != nil {
+		fmt.Printf("error building cost matrix: %v\n", err)
+		return
+	}
+	if len(r.old) > 0 {
+		fmt.Printf("previous order now: %.4f %s\n", r.old.tourLen(), costUnit())
+	}
+
+	fmt.Printf("using 2-Opt and moves at %.4f per changed leg\n", *prevW)
+	s1 := time.Now()
+	out := r.route(*prevW)
+	fmt.Println("optimization took:", time.Since(s1))
+
+	mv := r.moved(out)
+	fmt.Printf("final tour length: %.4f %s, %d of %d legs changed, %d stops moved\n", out.tourLen(), costUnit(), r.changed(out), len(out), len(mv))
+
+	rows := [][]string{{"lab", "change", "was", "ord", "shift"}}
+	for i, v := range out {
+		switch j, ok := r.was[v]; {
+		case !ok:
+			rows = append(rows, []string{v.lab, "added", "", strconv.Itoa(i + 1), ""})
+		case mv[v]:
+			fmt.Printf("moved: %s from %d to %d\n", v.lab, j+1, i+1)
+			rows = append(rows, []string{v.lab, "moved", strconv.Itoa(j + 1), strconv.Itoa(i + 1), strconv.Itoa(i - j)})
+		default:
+			rows = append(rows, []string{v.lab, "kept", strconv.Itoa(j + 1), strconv.Itoa(i + 1), strconv.Itoa(i - j)})
+		}
+	}
+	for _, v := range r.gone {
+		rows = append(rows, []string{v.lab, "removed", "", "", ""})
+	}
+
+	rName := outName()
+	fmt.Printf("writing results to %v and %v\n", *outFile, rName+"_diff.txt")
+	ctr, ctrDist := out.centPnt()
+	if err := writeFile(out, ctr, ctrDist, filepath.Join(dir, *outFile), *format, true, hdrLen(out)); err != nil {
+		fmt.Printf("error writing file: %v\n", err)
+		return
+	}
+	if err := writeRows(rows, filepath.Join(dir, rName+"_diff.txt")); err != nil {
+		fmt.Printf("error writing file: %v\n", err)
+		return
+	}
+
+	if *img {
+		fmt.Println("generating route and center plot")
+		if err := genRoute(out, ctr, rName+"_route"); err != nil {
+			fmt.Printf("error building route: %v\n", err)
+		}
+	}
+}
+
+// measure the input order without changing it, write each leg to an annotated file
+func methodEval(p pnts, dir string) {
+	const top = 5
+	e := p.eval()
+	tl := p.tourLen()
+	unit := costUnit()
+
+	fmt.Printf("tour length as given: %.4f %s\n", tl, unit)
+	fmt.Printf("return leg: %s to %s %.4f %s\n", p[len(p)-1].lab, p[0].lab, e.leg[0], unit)
+	rank := make(map[int]int)
+	fmt.Println("longest legs:")
+	for k, i := range e.long[:minInt(top, len(p))] {
+		rank[i] = k + 1
+		from := p[(i-1+len(p))%len(p)]
+		fmt.Printf("  %d. %s to %s %.4f %s (%.1f%%)\n", k+1, from.lab, p[i].lab, e.leg[i], unit, e.leg[i]/tl*100)
+	}
+	fmt.Printf("crossings: %d\n", e.nCrs)
+
+	// how much 2-Opt still finds from this order
+	optLen, gap := tl, 0.0
+	if len(p) >= 4 {
+		s1 := time.Now()
+		q := p.opt2SA(*rate, len(p) > 750, -1, false)
+		optLen = q.tourLen()
+		gap = (tl - optLen) / optLen * 100
+		fmt.Printf("2-Opt from this order: %.4f %s, given is %.2f%% longer (%v)\n", optLen, unit, gap, time.Since(s1))
+	}
+
+	rows := [][]string{
+		{"tour:", fmt.Sprintf("%.4f", tl) + unit},
+		{"return:", fmt.Sprintf("%.4f", e.leg[0]) + unit},
+		{"crossings:", strconv.Itoa(e.nCrs)},
+		{"2-Opt:", fmt.Sprintf("%.4f", optLen) + unit, fmt.Sprintf("%.2f%%", gap)},
+		{},
+		{"lab", "lat", "lon", "ord", "leg", "cum", "long", "cross"},
+	}
+	for i, v := range p {
+		r := ""
+		if rank[i] > 0 {
+			r = strconv.Itoa(rank[i])
+		}
+		rows = append(rows, []string{
+			v.lab,
+			strconv.FormatFloat(v.lat, 'f', 6, 64),
+			strconv.FormatFloat(v.lon, 'f', 6, 64),
+			strconv.Itoa(i + 1),
+			fmt.Sprintf("%.4f", e.leg[i]),
+			fmt.Sprintf("%.4f", e.cum[i]),
+			r,
+			strconv.Itoa(e.cross[i]),
+		})
+	}
+
+	rName := outName()
+	fmt.Printf("writing results to %v\n", rName+"_eval.txt")
+	if err := writeRows(rows, filepath.Join(dir, rName+"_eval.txt")); err != nil {
+		fmt.Printf("error writing file: %v\n", err)
+		return
+	}
+
+	if *img {
+		fmt.Println("generating route and center plot")
+		ctr, _ := p.centPnt()
+		if err := genRoute(p, ctr, rName+"_route"); err != nil {
+			fmt.Printf("error building route: %v\n", err)
+		}
+	}
+}
+
+// a file and map per crew route to crews/, each starts at the shared start stop
+func writeCrews(rts []pnts, dir string) error {
+	cPath := filepath.Join(dir, "crews")
+	if _, err := os.Stat(cPath); os.IsNotExist(err) {
+		os.Mkdir(cPath, os.ModeDir)
+	}
+	old, _ := filepath.Glob(filepath.Join(cPath, "crew_*"))
+	for _, f := range old {
+		os.Remove(f)
+	}
+
+	c := make([]cluster, len(rts))
+	for i, r := range rts {
+		nm := fmt.Sprintf("crew_%02d", i+1)
+		fmt.Printf("%s: %d stops, %.4f %s\n", nm, len(r)-1, r.tourLen(), costUnit())
+		ctr, ctrDist := r.centPnt()
+		if err := writeFile(r, ctr, ctrDist, filepath.Join(cPath, nm+".txt"), *format, true, r.tourLen()); err != nil {
+			return err
+		}
+		c[i] = cluster{ctr: r[0], cls: r}
+	}
+
+	if *img {
+		if err := genClsRoutes(c, filepath.Join(cPath, "crew_routes")); err != nil {
+			fmt.Printf("error building routes: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// cut the route into days, write a file and a map per day to days/
+func methodDays(out pnts, dir string) {
+	lim := dayLim{stops: *dayStops, dist: *dayKm, hrs: *dayHrs, svc: *service, kph: *speed}
+	base, err := dayBasePnt()
+	if err != nil {
+		fmt.Printf("error, could not parse %q: %v\n", *dayBase, err)
+		return
+	}
+
+	days, over := out.split(lim, base)
+	fmt.Printf("splitting route into %d days\n", len(days))
+	for _, v := range over {
+		fmt.Printf("warning, %s does not fit a day on its own\n", v.lab)
+	}
+
+	dPath := filepath.Join(dir, "days")
+	if _, err := os.Stat(dPath); os.IsNotExist(err) {
+		os.Mkdir(dPath, os.ModeDir)
+	}
+	old, _ := filepath.Glob(filepath.Join(dPath, "day_*"))
+	for _, f := range old {
+		os.Remove(f)
+	}
+
+	rows := [][]string{{"day", "stops", "first", "last", "length", "hours"}}
+	for i, d := range days {
+		nm := fmt.Sprintf("day_%02d", i+1)
+		ln, hrs := lim.measure(d, base)
+		fmt.Printf("%s: %d stops, %s to %s, %.4f %s, %.2f hours\n", nm, len(d), d[0].lab, d[len(d)-1].lab, ln, costUnit(), hrs)
+		rows = append(rows, []string{nm, strconv.Itoa(len(d)), d[0].lab, d[len(d)-1].lab, fmt.Sprintf("%.4f", ln), fmt.Sprintf("%.2f", hrs)})
+
+		// a base day is a closed tour from the base, otherwise an open path, either way ln long
+		t := d
+		if base != nil {
+			t = append(pnts{*base}, d...)
+		}
+		ctr, ctrDist := d.centPnt()
+		if err := writeFile(t, ctr, ctrDist, filepath.Join(dPath, nm+".txt"), *format, true, ln); err != nil {
+			fmt.Printf("error writing file: %v\n", err)
+			return
+		}
+		if *img {
+			if base != nil {
+				t = append(t, *base)
+			}
+			if err := genRoute(t, ctr, filepath.Join(dPath, nm+"_route")); err != nil {
+				fmt.Printf("error building route: %v\n", err)
+			}
+		}
+	}
+
+	if err := writeRows(rows, filepath.Join(dPath, "day_summary.txt")); err != nil {
+		fmt.Printf("error writing file: %v\n", err)
+	}
+}
+
+// -base as a stop labelled base, nil if unset
+func dayBasePnt() (*point, error) {
+	if *dayBase == "" {
+		return nil, nil
+	}
+	b, err := anchToPnt(*dayBase)
+	if err != nil {
+		return nil, err
+	}
+	b.lab = "base"
+	return &b, nil
+}
+
+// precedence rules from the pair and after columns
+func setPrec(p pnts, home point, dir string) error {
+	cols := make([]map[point]string, 2)
+	for i, col := range []string{*pairCol, *afterCol} {
+		if col == "" {
+			continue
+		}
+		cv, err := readCol(filepath.Join(dir, *inFile), col)
+		if err != nil {
+			return err
+		}
+		cols[i] = cv
+	}
+
+	g, err := newPrec(p, home, cols[0], cols[1])
+	if err != nil {
+		return err
+	}
+	prec = g
+	return nil
+}
+
+// locks from the lock column
+func setLock(p pnts, home point, dir string) error {
+	cv, err := readCol(filepath.Join(dir, *inFile), *lockCol)
+	if err != nil {
+		return err
+	}
+	l, err := newLock(p, home, cv)
+	if err != nil {
+		return err
+	}
+	lock = l
+	return nil
+}
+
+// precedence rules with their positions in the route and the length each one costs
+func writePrec(out pnts, dest string) error {
+	save := prec.binding(out)
+	pos, _ := prec.pos(out)
+
+	rows := [][]string{{"rule", "before", "ord", "after", "ord", "binding"}}
+	cnt := 0
+	for i, c := range prec.cons {
+		bnd := "no"
+		if save[i] > 0 {
+			bnd = fmt.Sprintf("%.4f", save[i]) + costUnit()
+			cnt++
+			fmt.Printf("binding: %s before %s (%s) costs %s\n", c.a.lab, c.b.lab, c.kind, bnd)
+		}
+		rows = append(rows, []string{c.kind, c.a.lab, strconv.Itoa(pos[c.a] + 1), c.b.lab, strconv.Itoa(pos[c.b] + 1), bnd})
+	}
+	fmt.Printf("%d of %d precedence rules binding, writing %v\n", cnt, len(prec.cons), filepath.Base(dest))
+	return writeRows(rows, dest)
+}
+
+// tab separated report rows
+func writeRows(rows [][]string, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Comma = '\t'
+	w.WriteAll(rows)
+	return w.Error()
+}
+
+// balanced limits from flags, with demand column if named
+func readLim(dir string) (clsLim, error) {
+	lim := clsLim{min: *cMin, max: *cMax, maxDem: *demMax}
+	if *demCol == "" {
+		if *demMax > 0 {
+			return lim, errors.New("-dmax needs a demand column, set -dcol")
+		}
+		return lim, nil
+	}
+
+	cv, err := readCol(filepath.Join(dir, *inFile), *demCol)
+	if err != nil {
+		return lim, err
+	}
+	lim.dem, err = colFloat(cv)
+	return lim, err
+}
+
+// cluster output file names, clsN.txt or center label when byLab
+func clsNames(c []cluster, byLab bool) []string {
+	nms := make([]string, len(c))
+	for i, v := range c {
+		nms[i] = "cls" + strconv.Itoa(i)
+		if byLab && v.ctr.lab != "" {
+			nms[i] = fileName(v.ctr.lab)
+		}
+	}
+	return txtNames(nms)
+}
+
+// .txt file names, a repeat or a name written alongside (noise, unassigned) gets _i on the end
+func txtNames(nms []string) []string {
+	out := make([]string, len(nms))
+	seen := map[string]bool{"noise": true, "unassigned": true}
+	for i, nm := range nms {
+		if seen[nm] {
+			nm += "_" + strconv.Itoa(i)
+		}
+		seen[nm] = true
+		out[i] = nm + ".txt"
+	}
+	return out
+}
+
+// download basemap tiles for a box into the -tiles dir so maps can be drawn offline
+func methodPrefetch(dir string) {
+	c := strings.Split(strings.Replace(*preBox, " ", "", -1), ",")
+	if len(c) != 4 {
+		fmt.Printf("error, -prefetch needs lat1,lon1,lat2,lon2, got %q\n", *preBox)
+		return
+	}
+	a, err := anchToPnt(c[0] + "," + c[1])
+	if err != nil {
+		fmt.Printf("error, could not parse %q: %v\n", *preBox, err)
+		return
+	}
+	b, err := anchToPnt(c[2] + "," + c[3])
+	if err != nil {
+		fmt.Printf("error, could not parse %q: %v\n", *preBox, err)
+		return
+	}
+	lo := point{math.Min(a.lat, b.lat), math.Min(a.lon, b.lon), ""}
+	hi := point{math.Max(a.lat, b.lat), math.Max(a.lon, b.lon), ""}
+
+	zs := strings.SplitN(*preZoom, "-", 2)
+	z0, err := strconv.Atoi(zs[0])
+	z1 := z0
+	if err == nil && len(zs) == 2 {
+		z1, err = strconv.Atoi(zs[1])
+	}
+	if err != nil || z0 < 0 || z1 < z0 || z1 > 19 {
+		fmt.Printf("error, -pzoom needs a zoom or range within 0-19, got %q\n", *preZoom)
+		return
+	}
+
+	td := *tilePath
+	if td == "" {
+		td = "tiles"
+	}
+	if strings.HasSuffix(strings.ToLower(td), ".mbtiles") {
+		fmt.Println("error, -prefetch writes a tile dir, not an .mbtiles file")
+		return
+	}
+	if !filepath.IsAbs(td) {
+		td = filepath.Join(dir, td)
+	}
+
+	fmt.Printf("fetching zoom %d-%d tiles into %s...\n", z0, z1, td)
+	got, skip, err := prefetch(td, *tileURL, lo, hi, z0, z1)
+	fmt.Printf("%d tiles fetched, %d already there\n", got, skip)
+	if err != nil {
+		fmt.Printf("error fetching tiles: %v\n", err)
+	}
+}
+
+func methodHK(p pnts) (pnts, bool) {
+	if len(p) > maxHK {
+		fmt.Printf("error, exact directed method is limited to %d nodes, use -m atsp\n", maxHK)
+		return pnts{}, true
+	}
+
+	fmt.Println("using exact directed (Held-Karp) method")
+	return p.heldKarp(), false
+}
+
+func methodATSP(p pnts) pnts {
+	const max3opt = 200
+
+	str := "using directed Or-Opt"
+	if len(p) <= max3opt {
+		str += " and 3-Opt segment exchange"
+	}
+	fmt.Println(str)
+
+	return p.atspOpt(max3opt)
+}
+
+func methodOrient(p pnts, s int, dir string) (pnts, pnts, error) {
+	bud := *budget
+	if *budHours > 0 {
+		bud = *budHours * *speed
+		if costUnit() == "min" {
+			bud = *budHours * 60
+		}
+	}
+
+	var score map[point]float64
+	if *scoreCol != "" {
+		cv, err := readCol(filepath.Join(dir, *inFile), *scoreCol)
+		if err != nil {
+			return nil, nil, err
+		}
+		if score, err = colFloat(cv); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fmt.Printf("choosing stops for a %.4f %s round trip from node %d\n", bud, costUnit(), s+1)
+	out, skip := p.orient(s, bud, score, *rate)
+
+	sum := func(ps pnts) float64 {
+		if score == nil {
+			return float64(len(ps))
+		}
+		var v float64
+		for _, loc := range ps {
+			v += score[loc]
+		}
+		return v
+	}
+	fmt.Printf("visiting %d of %d stops, score %.2f of %.2f\n", len(out), len(p), sum(out), sum(p))
+
+	return out, skip, nil
+}
+
+func methodNN(p pnts, s int, m bool) pnts {
+
+	if m {
+		fmt.Println("using multi-start nearest neighbor")
+		return p.nnaMul()
+	}
+
+	fmt.Printf("using nearest neighbor, starting at node: %d\n", s+1)
+	return p.nna(s)
+
+}
+
+// file processing
+func readFile(path string) (pnts, error) {
+	p, err := readPnts(path)
+	if err != nil {
+		return pnts{}, err
+	}
+
+	// check records
+	p, err = checkRec(p)
+	if err != nil {
+		return pnts{}, err
+	}
+
+	return p, nil
+}
+
+// read the input as given, repeats kept, for measuring an order
+func readOrder(path string) (pnts, error) {
+	p, err := readPnts(path)
+	if err != nil {
+		return pnts{}, err
+	}
+	if err := checkVal(p); err != nil {
+		return pnts{}, err
+	}
+
+	seen := make(map[point]bool, len(p))
+	dups := 0
+	for _, v := range p {
+		if seen[v] {
+			dups++
+		}
+		seen[v] = true
+	}
+	if dups > 0 {
+		fmt.Printf("warning, %d stops repeat, kept in order\n", dups)
+	}
+	return p, nil
+}
+
+// label, lat, lon rows without checks
+func readPnts(path string) (pnts, error) {
+
+	csvFile, err := os.Open(path)
+	if err != nil {
+		return pnts{}, err
+	}
+	defer csvFile.Close()
+
+	reader := csv.NewReader(csvFile)
+
+	// configure csv reader
+	reader.Comma = '\t' // set split token
+
+	records, err := reader.ReadAll() // -> [][]string
+	if err != nil {
+		return pnts{}, err
+	}
+	if len(records) == 0 {
+		return pnts{}, errors.New("empty input file")
+	}
+	records = records[1:] // drop header record
+
+	// check for empty LatLon
+	if err := checkEmp(records); err != nil {
+		return pnts{}, err
+	}
+
+	p := make(pnts, len(records))
+
+	for i, rec := range records {
+		p[i].lab = strings.TrimSpace(rec[0])
+		p[i].lat, err = strconv.ParseFloat(rec[1], 64)
+		if err != nil {
+			return pnts{}, err
+		}
+		p[i].lon, err = strconv.ParseFloat(rec[2], 64)
+		if err != nil {
+			return pnts{}, err
+		}
+	}
+
+	return p, nil
+}
+
+// read a route written by writeFile, formatted or not, in tour order
+func readRoute(path string) (pnts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return pnts{}, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1 // center and tour headers are short
+	records, err := reader.ReadAll()
+	if err != nil {
+		return pnts{}, err
+	}
+
+	// skip center: and tour: rows, then the column header
+	for len(records) > 0 && strings.HasSuffix(records[0][0], ":") {
+		records = records[1:]
+	}
+	if len(records) < 2 {
+		return pnts{}, errors.New("no stops in route file")
+	}
+	records = records[1:]
+
+	p := make(pnts, len(records))
+	for i, rec := range records {
+		if len(rec) < 3 {
+			return pnts{}, errors.New("short record! row: " + strconv.Itoa(i+1))
+		}
+		p[i].lab = strings.TrimSpace(rec[0])
+		if p[i].lat, err = strconv.ParseFloat(rec[1], 64); err != nil {
+			return pnts{}, err
+		}
+		if p[i].lon, err = strconv.ParseFloat(rec[2], 64); err != nil {
+			return pnts{}, err
+		}
+	}
+	return checkRec(p)
+}
+
+// read an extra named column, keyed by the point on the same row
+func readCol(path string, col string) (map[point]string, error) {
+
+	csvFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer csvFile.Close()
+
+	reader := csv.NewReader(csvFile)
+	reader.Comma = '\t'
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty input file")
+	}
+
+	ix := -1
+	for i, v := range records[0] {
+		if strings.EqualFold(strings.TrimSpace(v), col) {
+			ix = i
+			break
+		}
+	}
+	if ix == -1 {
+		return nil, errors.New("column not found: " + col)
+	}
+
+	out := make(map[point]string, len(records)-1)
+	for _, rec := range records[1:] {
+		var p point
+		p.lab = strings.TrimSpace(rec[0])
+		if p.lat, err = strconv.ParseFloat(rec[1], 64); err != nil {
+			return nil, err
+		}
+		if p.lon, err = strconv.ParseFloat(rec[2], 64); err != nil {
+			return nil, err
+		}
+		out[p] = strings.TrimSpace(rec[ix])
+	}
+
+	return out, nil
+}
+
+// parse column values as numbers, blank is zero
+func colFloat(cv map[point]string) (map[point]float64, error) {
+	out := make(map[point]float64, len(cv))
+	for p, v := range cv {
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("bad value for " + p.lab + ": " + v)
+		}
+		out[p] = f
+	}
+	return out, nil
+}
+
+func checkEmp(recs [][]string) error {
+	for i, rec := range recs {
+		if strings.TrimSpace(rec[1]) == "" || strings.TrimSpace(rec[2]) == "" {
+			return errors.New("missing LatLon! row: " + strconv.Itoa(i+2))
+		}
+	}
+	return nil
+}
+
+func checkRec(recs pnts) (pnts, error) {
+
+	if err := checkVal(recs); err != nil {
+		return pnts{}, err
+	}
+
+	// remove dups
+	distVals := make(map[point]struct{})
+	dups := []int{}
+
+	tmp := pnts{}
+	for i, rec := range recs {
+		if _, ok := distVals[rec]; !ok {
+			distVals[rec] = struct{}{}
+			tmp = append(tmp, rec)
+		} else {
+			dups = append(dups, i+2)
+		}
+	}
+
+	if len(recs)-len(tmp) == 1 {
+		fmt.Printf("removed %d duplicate at row:%v\n", len(recs)-len(tmp), dups)
+	} else if len(recs)-len(tmp) > 1 {
+		fmt.Printf("removed %d duplicates at rows:%v\n", len(recs)-len(tmp), dups)
+	}
+
+	return tmp, nil
+}
+
+// check populated and valid coords
+func checkVal(recs pnts) error {
+	for i, rec := range recs {
+		if rec.lab == "" {
+			return errors.New("unpopulated record! row: " + strconv.Itoa(i+2) + " column: label")
+		}
+		if rec.lat == 0 {
+			return errors.New("unpopulated record! row: " + strconv.Itoa(i+2) + " column: lat")
+		}
+		if rec.lon == 0 {
+			return errors.New("unpopulated record! row: " + strconv.Itoa(i+2) + " column: lon")
+		}
+		chk := s2.LatLngFromDegrees(rec.lat, rec.lon)
+		if !chk.IsValid() {
+			return errors.New("invalid LatLng, row: " + strconv.Itoa(i+2))
+		}
+	}
+	return nil
+}
+
+// ln of 0 or more adds the stop count to the formatted header, and on a route a tour: row with ln
+func writeFile(p pnts, c point, d float64, dest string, format bool, route bool, ln float64) error {
+	tour := make([][]string, len(p))
 
 	if format {
 		for i, loc := range p {
@@ -412,15 +1713,24 @@ func writeFile(p pnts, c point, d float64, dest string, format bool) error {
 				strconv.Itoa(i + 1),
 			}
 		}
-		hdr := [][]string{
+		rows := [][]string{
 			{"center:",
 				strconv.FormatFloat(c.lat, 'f', 6, 64),
 				strconv.FormatFloat(c.lon, 'f', 6, 64),
 				fmt.Sprintf("%.2f", d/float64(len(p))) + "km avg dist"},
-			{},
-			{"lab", "lat", "lon", "ord"},
 		}
-		tour = append(hdr, tour...)
+		if ln >= 0 {
+			rows[0] = append(rows[0], strconv.Itoa(len(p))+" stops")
+			if route {
+				rows = append(rows, []string{"tour:", fmt.Sprintf("%.4f", ln) + costUnit()})
+			}
+		}
+		cols := []string{"lab", "lat", "lon", "ord"}
+		if route {
+			cols = addLegCols(p, tour, cols)
+		}
+		rows = append(rows, []string{}, cols)
+		tour = append(rows, tour...)
 	} else {
 		for i, loc := range p {
 			tour[i] = []string{
@@ -454,6 +1764,81 @@ func writeFile(p pnts, c point, d float64, dest string, format bool) error {
 
 }
 
+// -xcols on a route: leg from the previous stop, running length, drive minutes at -kph,
+// bearing and compass heading to the next stop. legs use cost, the same as tourLen
+func addLegCols(p pnts, rows [][]string, hdr []string) []string {
+	var cum float64
+	for i := range p {
+		leg := 0.0
+		if i > 0 {
+			leg = cost(p[i-1], p[i])
+		}
+		cum += leg
+		for _, c := range outCols {
+			switch c {
+			case "leg":
+				rows[i] = append(rows[i], fmt.Sprintf("%.4f", leg))
+			case "cum":
+				rows[i] = append(rows[i], fmt.Sprintf("%.4f", cum))
+			case "time":
+				min := leg / *speed * 60
+				if costUnit() == "min" {
+					min = leg
+				}
+				rows[i] = append(rows[i], fmt.Sprintf("%.1f", min))
+			case "bear":
+				b := bearing(p[i], p[(i+1)%len(p)])
+				rows[i] = append(rows[i], fmt.Sprintf("%.1f", b), compass(b))
+			}
+		}
+	}
+
+	for _, c := range outCols {
+		hdr = append(hdr, c)
+		if c == "bear" {
+			hdr = append(hdr, "head")
+		}
+	}
+	return hdr
+}
+
+// map style from the map flags
+func setStyle() error {
+	if *mapW < 1 || *mapH < 1 || *mkSize <= 0 {
+		return errors.New("map size and marker size must be positive")
+	}
+	clrs := strings.Split(*mapClrs, ",")
+	if len(clrs) != 4 {
+		return fmt.Errorf("%q needs 4 colors: stops, path, start, end", *mapClrs)
+	}
+	var err error
+	for i, c := range []*color.RGBA{&style.mkr, &style.path, &style.first, &style.last} {
+		if *c, err = parseClr(clrs[i]); err != nil {
+			return err
+		}
+	}
+	style.w, style.h, style.size, style.labels = *mapW, *mapH, *mkSize, *mapLab
+
+	style.fmts = make(map[string]bool)
+	for _, v := range strings.Split(*mapFmt, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		ok := false
+		for _, f := range mapFmts {
+			ok = ok || v == f
+		}
+		if !ok {
+			return fmt.Errorf("%q is not a valid map format, valid formats: %s", v, strings.Join(mapFmts, ", "))
+		}
+		style.fmts[v] = true
+	}
+	if style.fmts["html"] {
+		return leafAssets()
+	}
+	return nil
+}
+
 // build sorted method string
 func dispMETH() string {
 	arrOrd := []int{}
@@ -477,6 +1862,15 @@ func inOpt(inStr string) bool {
 	return false
 }
 
+func inCls(inStr string) bool {
+	for _, v := range methCLS {
+		if inStr == v {
+			return true
+		}
+	}
+	return false
+}
+
 // convert flag string to point
 func anchToPnt(inStr string) (point, error) {
 	clnStr := strings.Replace(inStr, " ", "", -1)
@@ -499,6 +1893,19 @@ func anchToPnt(inStr string) (point, error) {
 	return point{lat, lon, "anchor"}, nil
 }
 
+// tour length for the -o file header, -1 (none) unless -hdr
+func hdrLen(t pnts) float64 {
+	if !*hdrRows {
+		return -1
+	}
+	return t.tourLen()
+}
+
+// -o without its extension, the stem other outputs are named from
+func outName() string {
+	return strings.TrimSuffix(*outFile, filepath.Ext(*outFile))
+}
+
 // remove files in dir
 func remFiles(dir string) error {
 	d, err := os.Open(dir)
@@ -510,16 +1917,14 @@ func remFiles(dir string) error {
 	if err != nil {
 		return err
 	}
+	// every stop list and map a clustering run writes, whatever it was named
 	for _, name := range fls {
-		isOut, _ := regexp.MatchString(`^cls\d+\.txt$`, name)
-		isImg := name == "clusters.png"
-		if isOut || isImg {
-			err = os.Remove(filepath.Join(dir, name))
-			if err != nil {
+		switch filepath.Ext(name) {
+		case ".txt", ".png", ".svg", ".html":
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
 				return err
 			}
 		}
-
 	}
 	return nil
 }
@@ -1,61 +1,246 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"image"
 	"image/color"
+	"math"
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/flopp/go-staticmaps"
 	"github.com/fogleman/gg"
 	"github.com/golang/geo/s2"
 )
 
-// plot points with highlighted center
+// plot points with highlighted center, numbered in input order
 func genPoints(p pnts, c point, nm string) error {
-	ctx := sm.NewContext()
-	ctx.SetSize(800, 600)
-	for _, loc := range p {
-		ctx.AddMarker(sm.NewMarker(s2.LatLngFromDegrees(loc.lat, loc.lon), color.RGBA{255, 51, 51, 0xff}, 10.0))
+	d := mapDraw{layer: "stops"}
+	for i, loc := range p {
+		d.mark(loc, color.RGBA{255, 51, 51, 0xff}, 10.0, strconv.Itoa(i+1))
+		d.popup(loc.lab, "row "+strconv.Itoa(i+1))
 	}
-	ctx.AddMarker(sm.NewMarker(s2.LatLngFromDegrees(c.lat, c.lon), color.RGBA{10, 10, 255, 0xff}, 12.0))
+	d.layer = "center"
+	d.mark(c, color.RGBA{10, 10, 255, 0xff}, 12.0, "")
+	d.popup("center")
+	return d.save(nm)
+}
 
-	img, err := ctx.Render()
-	if err != nil {
-		return err
+// build route from ordered points
+// markers are numbered in visit order with the start and end highlighted, arrows show the direction
+func genRoute(p pnts, c point, nm string) error {
+	d := mapDraw{layer: "route"}
+	d.line(p, style.path, 3.0)
+
+	// arrow heads sized to the map, on legs long enough to show them
+	ext := p.extent()
+	for i := 1; i < len(p); i++ {
+		if ext == 0 || dirLen(p[i-1], p[i]) < ext*0.04 {
+			continue
+		}
+		d.line(arrowHead(p[i-1], p[i], ext*0.015), style.path, 3.0)
 	}
 
-	if _, err := os.Stat(nm + ".png"); !os.IsNotExist(err) {
-		os.Remove(nm + ".png")
+	d.layer = "center"
+	d.mark(c, color.RGBA{10, 10, 255, 0xff}, style.size, "")
+	d.popup("center")
+	d.layer = "route"
+	for i := len(p) - 1; i >= 0; i-- { // start drawn last, on top
+		clr, size := style.mkr, style.size
+		switch i {
+		case 0:
+			clr, size = style.first, style.size*1.4
+		case len(p) - 1:
+			clr, size = style.last, style.size*1.4
+		}
+		lab := strconv.Itoa(i + 1)
+		if style.labels {
+			lab += " " + p[i].lab
+		}
+		d.mark(p[i], clr, size, lab)
+		if i == 0 {
+			d.popup(p[i].lab, "stop 1 (start)")
+		} else {
+			d.popup(p[i].lab, "stop "+strconv.Itoa(i+1), fmt.Sprintf("leg %.2f %s", cost(p[i-1], p[i]), costUnit()))
+		}
 	}
+	return d.save(nm)
+}
 
-	if err := gg.SavePNG(nm+".png", img); err != nil {
-		return err
+// plot clusters with highlighted center
+// noise points (unclustered) are drawn in grey
+func genClusters(c []cluster, noise pnts, nm string) error {
+	d := mapDraw{layer: "noise"}
+	mkr := color.RGBA{10, 10, 255, 0xff}
+
+	for _, loc := range noise {
+		d.mark(loc, noiseClr, 8.0, "")
+		d.popup(loc.lab, "noise")
 	}
 
-	return nil
+	newPal := clsPal(len(c), mkr)
+
+	for i, cls := range c {
+		clr := newPal[i]
+		d.layer = "cluster " + strconv.Itoa(i)
+		for _, loc := range cls.cls {
+			d.mark(loc, clr, 10.0, "")
+			d.popup(loc.lab, d.layer)
+		}
+		d.mark(cls.ctr, mkr, 12.0, strconv.Itoa(i)+" ("+strconv.Itoa(len(cls.cls))+")")
+		d.popup(d.layer+" center", strconv.Itoa(len(cls.cls))+" stops")
+	}
+	return d.save(nm)
 }
 
-// build route from ordered points
-func genRoute(p pnts, c point, nm string) error {
-	ctx := sm.NewContext()
-	ctx.SetSize(800, 600)
-	for _, loc := range p {
-		ctx.AddMarker(sm.NewMarker(s2.LatLngFromDegrees(loc.lat, loc.lon), color.RGBA{255, 51, 51, 0xff}, 10.0))
+// plot each cluster's closed tour in its own color
+func genClsRoutes(c []cluster, nm string) error {
+	d := clsRouteDraw(c)
+	return d.save(nm)
+}
+
+func clsRouteDraw(c []cluster) mapDraw {
+	var d mapDraw
+	mkr := color.RGBA{10, 10, 255, 0xff}
+	newPal := clsPal(len(c), mkr)
+
+	for i, cls := range c {
+		if len(cls.cls) == 0 {
+			continue
+		}
+		clr := newPal[i]
+		d.layer = "cluster " + strconv.Itoa(i)
+		for j, loc := range cls.cls {
+			d.mark(loc, clr, 8.0, "")
+			if j == 0 {
+				d.popup(loc.lab, d.layer, "stop 1 (start)")
+			} else {
+				d.popup(loc.lab, d.layer, "stop "+strconv.Itoa(j+1), fmt.Sprintf("leg %.2f %s", cost(cls.cls[j-1], loc), costUnit()))
+			}
+		}
+		d.line(append(append(pnts{}, cls.cls...), cls.cls[0]), clr, 3.0) // back to the start, as tourLen counts it
+
+		// highlight first stop
+		d.mark(cls.cls[0], mkr, 12.0, strconv.Itoa(i))
+		d.popup(cls.cls[0].lab, d.layer+" start")
 	}
-	ctx.AddMarker(sm.NewMarker(s2.LatLngFromDegrees(c.lat, c.lon), color.RGBA{10, 10, 255, 0xff}, 12.0))
+	return d
+}
 
-	path := make([]s2.LatLng, len(p))
-	for i, loc := range p {
-		path[i] = s2.LatLngFromDegrees(loc.lat, loc.lon)
+// what a map shows, drawn in order: lines first, then markers
+// marks and lines go on the current layer, which html maps can toggle
+type mapDraw struct {
+	mks   []mapMk
+	lines []mapLine
+	layer string
+}
+
+type mapMk struct {
+	p     point
+	clr   color.RGBA
+	size  float64
+	lab   string
+	layer string
+	pop   []string // html popup lines
+}
+
+type mapLine struct {
+	ps    pnts
+	clr   color.RGBA
+	w     float64
+	layer string
+}
+
+func (d *mapDraw) mark(p point, clr color.RGBA, size float64, lab string) {
+	d.mks = append(d.mks, mapMk{p: p, clr: clr, size: size, lab: lab, layer: d.layer})
+}
+
+func (d *mapDraw) line(ps pnts, clr color.RGBA, w float64) {
+	d.lines = append(d.lines, mapLine{append(pnts{}, ps...), clr, w, d.layer})
+}
+
+// popup lines for the last mark
+func (d *mapDraw) popup(lines ...string) {
+	if len(d.mks) > 0 {
+		d.mks[len(d.mks)-1].pop = lines
 	}
+}
 
-	ctx.AddPath(sm.NewPath(path, color.RGBA{155, 51, 255, 0xff}, 3.0))
+// every point drawn
+func (d *mapDraw) pnts() pnts {
+	var out pnts
+	for _, m := range d.mks {
+		out = append(out, m.p)
+	}
+	for _, l := range d.lines {
+		out = append(out, l.ps...)
+	}
+	return out
+}
 
-	img, err := ctx.Render()
-	if err != nil {
+// write the map in each of the -mfmt formats, nm plus the extension
+func (d *mapDraw) save(nm string) error {
+	if style.fmts["svg"] {
+		f, err := os.Create(nm + ".svg")
+		if err != nil {
+			return err
+		}
+		err = d.svg(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if style.fmts["png"] {
+		if err := d.png(nm); err != nil {
+			return err
+		}
+	}
+	if style.fmts["html"] {
+		f, err := os.Create(nm + ".html")
+		if err != nil {
+			return err
+		}
+		err = d.html(f, nm)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
 		return err
 	}
+	return nil
+}
+
+// render and write nm.png
+// local tiles or no basemap when set, otherwise online tiles falling back to no basemap
+func (d *mapDraw) png(nm string) error {
+	var img image.Image
+	var err error
+	switch {
+	case *noBase:
+		img = d.plain(nil)
+	case *tilePath != "":
+		src, serr := openTiles(*tilePath)
+		if serr != nil {
+			fmt.Printf("warning, %v, drawing without a basemap\n", serr)
+		} else {
+			defer src.close()
+		}
+		img = d.plain(src)
+	case mapsOffline:
+		img = d.plain(nil)
+	default:
+		if img, err = d.online(); err != nil {
+			fmt.Printf("warning, map tiles failed (%v), drawing this and later maps without a basemap\n", err)
+			mapsOffline = true
+			img = d.plain(nil)
+		}
+	}
 
 	if _, err := os.Stat(nm + ".png"); !os.IsNotExist(err) {
 		os.Remove(nm + ".png")
@@ -68,55 +253,134 @@ func genRoute(p pnts, c point, nm string) error {
 	return nil
 }
 
-// plot clusters with highlighted center
-func genClusters(c []cluster, nm string) error {
+// set once online tiles have failed, so a run without a connection waits on them only once
+var mapsOffline bool
 
+// staticmaps render on downloaded tiles, given up after mapWait
+func (d *mapDraw) online() (image.Image, error) {
 	ctx := sm.NewContext()
-	ctx.SetSize(800, 600)
-	mkr := color.RGBA{10, 10, 255, 0xff}
+	ctx.SetSize(style.w, style.h)
+	for _, l := range d.lines {
+		path := make([]s2.LatLng, len(l.ps))
+		for i, loc := range l.ps {
+			path[i] = s2.LatLngFromDegrees(loc.lat, loc.lon)
+		}
+		ctx.AddPath(sm.NewPath(path, l.clr, l.w))
+	}
+	for _, m := range d.mks {
+		mk := sm.NewMarker(s2.LatLngFromDegrees(m.p.lat, m.p.lon), m.clr, m.size)
+		if m.lab != "" {
+			mk.Label = m.lab
+			mk.LabelColor = color.RGBA{0xfe, 0xfe, 0xfa, 0xff}
+		}
+		ctx.AddMarker(mk)
+	}
+
+	type res struct {
+		img image.Image
+		err error
+	}
+	ch := make(chan res, 1)
+	go func() {
+		img, err := ctx.Render()
+		ch <- res{img, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.img, r.err
+	case <-time.After(mapWait):
+		return nil, errors.New("timed out")
+	}
+}
+
+// map image style, set from the map flags
+type mapStyle struct {
+	w, h   int     // canvas (px)
+	size   float64 // route marker size
+	mkr    color.RGBA
+	path   color.RGBA
+	first  color.RGBA
+	last   color.RGBA
+	labels bool // stop labels after the visit number
+	fmts   map[string]bool
+}
+
+// available map file formats
+var mapFmts = []string{"png", "svg", "html"}
+
+var style = mapStyle{
+	w:     800,
+	h:     600,
+	size:  14,
+	mkr:   color.RGBA{255, 51, 51, 0xff},
+	path:  color.RGBA{155, 51, 255, 0xff},
+	first: color.RGBA{51, 204, 51, 0xff},
+	last:  color.RGBA{51, 51, 51, 0xff},
+	fmts:  map[string]bool{"png": true},
+}
+
+// parse a hex color, rrggbb or #rrggbb
+func parseClr(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("%q is not a hex color (rrggbb)", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// width or height of the stops' bounding box, whichever is larger, in degrees of latitude
+func (ps *pnts) extent() float64 {
+	if len(*ps) == 0 {
+		return 0
+	}
+	lo, hi := (*ps)[0], (*ps)[0]
+	for _, p := range *ps {
+		lo.lat, lo.lon = math.Min(lo.lat, p.lat), math.Min(lo.lon, p.lon)
+		hi.lat, hi.lon = math.Max(hi.lat, p.lat), math.Max(hi.lon, p.lon)
+	}
+	k := math.Cos((lo.lat + hi.lat) / 2 * math.Pi / 180)
+	return math.Max(hi.lat-lo.lat, (hi.lon-lo.lon)*k)
+}
+
+// leg a to b in degrees of latitude, the lon part scaled to the same ground length
+func dirLen(a, b point) float64 {
+	k := math.Cos((a.lat + b.lat) / 2 * math.Pi / 180)
+	return math.Hypot(b.lat-a.lat, (b.lon-a.lon)*k)
+}
+
+// two barbs and the tip of an arrow at the middle of leg a to b, barbs l long (degrees of latitude)
+func arrowHead(a, b point, l float64) pnts {
+	const spread = 25 * math.Pi / 180
+	k := math.Cos((a.lat + b.lat) / 2 * math.Pi / 180)
+	tip := point{lat: (a.lat + b.lat) / 2, lon: (a.lon + b.lon) / 2}
+	ang := math.Atan2(b.lat-a.lat, (b.lon-a.lon)*k)
 
-	//contruct palette
-	del := len(palette) - len(c)
+	barb := func(off float64) point {
+		t := ang + math.Pi + off
+		return point{lat: tip.lat + l*math.Sin(t), lon: tip.lon + l*math.Cos(t)/k}
+	}
+	return pnts{barb(spread), tip, barb(-spread)}
+}
+
+// build n distinct cluster colors, avoiding mkr
+func clsPal(n int, mkr color.RGBA) []color.RGBA {
+	del := len(palette) - n
 	newPal := make([]color.RGBA, len(palette))
 	if del < 0 {
 		copy(newPal, palette)
 
-		for len(newPal) < len(c) {
+		for len(newPal) < n {
 			rnd := randColor()
-			if !inPal(rnd) && rnd != mkr {
+			if !inPal(rnd) && rnd != mkr && rnd != noiseClr {
 				newPal = append(newPal, rnd)
 			}
 		}
 	} else {
 		newPal = shufflePal(palette)
-		newPal = newPal[:len(c)]
-	}
-
-	for i, cls := range c {
-		clr := newPal[i]
-		for _, loc := range cls.cls {
-			ctx.AddMarker(sm.NewMarker(s2.LatLngFromDegrees(loc.lat, loc.lon), clr, 10.0))
-		}
-		ctrPoint := sm.NewMarker(s2.LatLngFromDegrees(cls.ctr.lat, cls.ctr.lon), mkr, 12.0)
-		ctrPoint.Label = strconv.Itoa(i)
-		ctrPoint.LabelColor = color.RGBA{0xfe, 0xfe, 0xfa, 0xff}
-		ctx.AddMarker(ctrPoint)
+		newPal = newPal[:n]
 	}
-
-	img, err := ctx.Render()
-	if err != nil {
-		return err
-	}
-
-	if _, err := os.Stat(nm + ".png"); !os.IsNotExist(err) {
-		os.Remove(nm + ".png")
-	}
-
-	if err := gg.SavePNG(nm+".png", img); err != nil {
-		return err
-	}
-
-	return nil
+	return newPal
 }
 
 func randColor() color.RGBA {
@@ -137,6 +401,8 @@ func inPal(c color.RGBA) bool {
 	return false
 }
 
+var noiseClr = color.RGBA{0x99, 0x99, 0x99, 0xff}
+
 var palette = []color.RGBA{
 	color.RGBA{0xfd, 0xe, 0x35, 0xff},
 	color.RGBA{0xff, 0x60, 0x37, 0xff},
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stop to stop costs replacing straight lines in the optimizers
+// rows are from, columns are to
+type costMatrix struct {
+	ix   map[point]int
+	dist [][]float64 // km
+	dur  [][]float64 // minutes
+	cost [][]float64 // the one in use
+	unit string
+}
+
+// active matrix, nil uses geoDist
+var mat *costMatrix
+
+// walking/parking speed off the network, also times straight line fallbacks
+const snapKPH = 15.0
+
+// cost between two stops, straight line if either is not in the matrix
+func cost(a, b point) float64 {
+	if mat != nil {
+		if i, ok := mat.ix[a]; ok {
+			if j, ok := mat.ix[b]; ok {
+				return mat.cost[i][j]
+			}
+		}
+	}
+	return geoDist(a, b)
+}
+
+// choose the matrix used by cost, "dist" or "time"
+func (m *costMatrix) use(metric string) {
+	m.cost, m.unit = m.dist, "km"
+	if metric == "time" && m.dur != nil {
+		m.cost, m.unit = m.dur, "min"
+	}
+}
+
+// unit of tour lengths
+func costUnit() string {
+	if mat != nil {
+		return mat.unit
+	}
+	return "km"
+}
+
+func newMatrix(ps pnts) *costMatrix {
+	m := &costMatrix{ix: make(map[point]int, len(ps))}
+	m.dist = make([][]float64, len(ps))
+	m.dur = make([][]float64, len(ps))
+	for i, p := range ps {
+		m.ix[p] = i
+		m.dist[i] = make([]float64, len(ps))
+		m.dur[i] = make([]float64, len(ps))
+	}
+	m.use("dist")
+	return m
+}
+
+// stop to stop road distances and drive times
+// paths are shortest on the metric ("dist" or "time") the matrix will use
+// stops snap to the nearest road node, the snap leg is added at straight line distance
+// unreachable pairs fall back to straight line
+func (g *roadGraph) matrix(ps pnts, metric string) *costMatrix {
+	m := newMatrix(ps)
+	src := make([]int, len(ps))
+	off := make([]float64, len(ps))
+	for i, p := range ps {
+		src[i], off[i] = g.snap(p)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	miss := 0
+	jobs := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				km, hr := g.dijkstra(src[i], src, metric == "time")
+				for j := range ps {
+					switch {
+					case i == j:
+					case src[i] == -1 || src[j] == -1 || math.IsInf(km[j], 1):
+						m.dist[i][j] = geoDist(ps[i], ps[j])
+						m.dur[i][j] = m.dist[i][j] / snapKPH * 60
+						mu.Lock()
+						miss++
+						mu.Unlock()
+					default:
+						m.dist[i][j] = off[i] + km[j] + off[j]
+						m.dur[i][j] = (hr[j] + (off[i]+off[j])/snapKPH) * 60
+					}
+				}
+			}
+		}()
+	}
+	for i := range ps {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	m.use(metric)
+
+	if miss > 0 {
+		fmt.Printf("warning, %d stop pairs not connected by road, using straight line\n", miss)
+	}
+	return m
+}
+
+// one to many shortest paths, on hours if byTime otherwise km
+// stops once every target is settled, returns km and hours to each target
+// https://en.wikipedia.org/wiki/Dijkstra%27s_algorithm
+func (g *roadGraph) dijkstra(s int, trg []int, byTime bool) ([]float64, []float64) {
+	km := make([]float64, len(trg))
+	hr := make([]float64, len(trg))
+	for i := range km {
+		km[i] = math.Inf(1)
+	}
+	if s == -1 {
+		return km, hr
+	}
+
+	want := make(map[int][]int)
+	for i, t := range trg {
+		if t != -1 {
+			want[t] = append(want[t], i)
+		}
+	}
+	left := len(want)
+
+	wt := map[int]float64{s: 0}
+	dist := map[int]float64{s: 0}
+	tm := map[int]float64{s: 0}
+	done := make(map[int]bool)
+	q := &nodeHeap{{s, 0}}
+	for q.Len() > 0 && left > 0 {
+		cur := heap.Pop(q).(nodeDist)
+		if done[cur.n] {
+			continue
+		}
+		done[cur.n] = true
+
+		if ix, ok := want[cur.n]; ok {
+			for _, i := range ix {
+				km[i], hr[i] = dist[cur.n], tm[cur.n]
+			}
+			left--
+		}
+
+		for _, e := range g.adj[cur.n] {
+			nw := cur.d + e.km
+			if byTime {
+				nw = cur.d + e.hr
+			}
+			if w, ok := wt[e.to]; !ok || nw < w {
+				wt[e.to] = nw
+				dist[e.to] = dist[cur.n] + e.km
+				tm[e.to] = tm[cur.n] + e.hr
+				heap.Push(q, nodeDist{e.to, nw})
+			}
+		}
+	}
+	return km, hr
+}
+
+// priority queue for dijkstra
+type nodeDist struct {
+	n int
+	d float64
+}
+
+type nodeHeap []nodeDist
+
+func (h nodeHeap) Len() int            { return len(h) }
+func (h nodeHeap) Less(i, j int) bool  { return h[i].d < h[j].d }
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(nodeDist)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// engine style json table, distances in meters and durations in seconds
+// rows follow the input order, or labels when present
+type jsonTable struct {
+	Labels    []string    `json:"labels,omitempty"`
+	Distances [][]float64 `json:"distances,omitempty"`
+	Durations [][]float64 `json:"durations,omitempty"`
+}
+
+// load a stop cost matrix from file
+// .json is an engine table, otherwise a square tsv/csv keyed by label:
+// first row is a blank cell then the to labels, each row after is the from label then costs
+func readMatrix(path string, ps pnts, metric string) (*costMatrix, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return readJSONMatrix(path, ps, metric)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = '\t'
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		reader.Comma = ','
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("empty matrix file")
+	}
+
+	if len(records[0])-1 != len(ps) || len(records)-1 != len(ps) {
+		return nil, fmt.Errorf("matrix is %dx%d for %d stops", len(records)-1, len(records[0])-1, len(ps))
+	}
+
+	// column and row to stop index
+	var colLab, rowLab []string
+	for i, rec := range records[1:] {
+		colLab = append(colLab, records[0][i+1])
+		rowLab = append(rowLab, rec[0])
+	}
+	col, err := matOrder(colLab, ps)
+	if err != nil {
+		return nil, err
+	}
+	row, err := matOrder(rowLab, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newMatrix(ps)
+	vals := m.dist
+	if metric == "time" {
+		vals = m.dur
+	}
+	for r, rec := range records[1:] {
+		for c := 1; c < len(rec); c++ {
+			v, err := strconv.ParseFloat(strings.TrimSpace(rec[c]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad matrix value row %d column %d: %v", r+2, c+1, err)
+			}
+			vals[row[r]][col[c-1]] = v
+		}
+	}
+
+	if metric == "time" {
+		m.dist = nil
+	} else {
+		m.dur = nil
+	}
+	m.use(metric)
+	return m, nil
+}
+
+func readJSONMatrix(path string, ps pnts, metric string) (*costMatrix, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tbl jsonTable
+	if err := json.Unmarshal(b, &tbl); err != nil {
+		return nil, err
+	}
+
+	// row order to stop index
+	ord := make([]int, len(ps))
+	for i := range ord {
+		ord[i] = i
+	}
+	if len(tbl.Labels) > 0 {
+		if ord, err = matOrder(tbl.Labels, ps); err != nil {
+			return nil, err
+		}
+	}
+
+	m := newMatrix(ps)
+	fill := func(src [][]float64, dst [][]float64, scale float64) error {
+		if len(src) != len(ps) || len(ord) != len(ps) {
+			return fmt.Errorf("matrix has %d rows for %d stops", len(src), len(ps))
+		}
+		for i, row := range src {
+			if len(row) != len(ps) {
+				return fmt.Errorf("matrix row %d has %d values for %d stops", i+1, len(row), len(ps))
+			}
+			for j, v := range row {
+				dst[ord[i]][ord[j]] = v * scale
+			}
+		}
+		return nil
+	}
+
+	if tbl.Distances != nil {
+		if err := fill(tbl.Distances, m.dist, 1.0/1000); err != nil {
+			return nil, err
+		}
+	} else {
+		m.dist = nil
+	}
+	if tbl.Durations != nil {
+		if err := fill(tbl.Durations, m.dur, 1.0/60); err != nil {
+			return nil, err
+		}
+	} else {
+		m.dur = nil
+	}
+
+	if metric == "time" && m.dur == nil || metric == "dist" && m.dist == nil {
+		return nil, errors.New("matrix has no " + metric + " values")
+	}
+	m.use(metric)
+	return m, nil
+}
+
+// write the straight line matrix of the stops (active distance model) in the readMatrix format
+func writeMatrix(path string, ps pnts) error {
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		tbl := jsonTable{Labels: make([]string, len(ps)), Distances: make([][]float64, len(ps))}
+		for i, a := range ps {
+			tbl.Labels[i] = a.lab
+			tbl.Distances[i] = make([]float64, len(ps))
+			for j, b := range ps {
+				tbl.Distances[i][j] = math.Round(geoDist(a, b) * 1000)
+			}
+		}
+		b, err := json.Marshal(tbl)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, b, 0644)
+	}
+
+	rows := make([][]string, len(ps)+1)
+	rows[0] = append([]string{""}, make([]string, len(ps))...)
+	for i, a := range ps {
+		rows[0][i+1] = a.lab
+		rows[i+1] = make([]string, len(ps)+1)
+		rows[i+1][0] = a.lab
+		for j, b := range ps {
+			rows[i+1][j+1] = strconv.FormatFloat(geoDist(a, b), 'f', 4, 64)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Comma = '\t'
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		w.Comma = ','
+	}
+	w.WriteAll(rows)
+	return w.Error()
+}
+
+// stop index of each matrix label
+// labels in the same order as the stops match by position, otherwise labels must be unique
+func matOrder(labs []string, ps pnts) ([]int, error) {
+	out := make([]int, len(labs))
+	inOrd := len(labs) == len(ps)
+	for i, v := range labs {
+		out[i] = i
+		if inOrd && strings.TrimSpace(v) != ps[i].lab {
+			inOrd = false
+		}
+	}
+	if inOrd {
+		return out, nil
+	}
+
+	labIx := make(map[string]int, len(ps))
+	for i, p := range ps {
+		if _, ok := labIx[p.lab]; ok {
+			return nil, errors.New("duplicate label, can not key matrix: " + p.lab)
+		}
+		labIx[p.lab] = i
+	}
+
+	seen := make(map[int]bool)
+	for i, v := range labs {
+		ix, ok := labIx[strings.TrimSpace(v)]
+		if !ok {
+			return nil, errors.New("matrix label not in stops: " + v)
+		}
+		if seen[ix] {
+			return nil, errors.New("matrix label repeated: " + v)
+		}
+		seen[ix] = true
+		out[i] = ix
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+//go:build mbtiles
+// +build mbtiles
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"image"
+	"strconv"
+
+	_ "github.com/mattn/go-sqlite3" // needs cgo
+)
+
+// raster MBTiles, a sqlite file with TMS rows (counted from the south)
+// https://github.com/mapbox/mbtiles-spec
+type mbTiles struct {
+	db *sql.DB
+}
+
+func openMB(path string) (tileSrc, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return mbTiles{db}, nil
+}
+
+func (m mbTiles) tile(z, x, y int) (image.Image, error) {
+	var b []byte
+	row := m.db.QueryRow("SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", z, x, (1<<uint(z))-1-y)
+	if err := row.Scan(&b); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	return img, err
+}
+
+// metadata maxzoom, else the deepest zoom in the tiles table
+func (m mbTiles) maxZoom() int {
+	var v string
+	if m.db.QueryRow("SELECT value FROM metadata WHERE name = 'maxzoom'").Scan(&v) == nil {
+		if z, err := strconv.Atoi(v); err == nil {
+			return z
+		}
+	}
+	var z sql.NullInt64
+	if m.db.QueryRow("SELECT MAX(zoom_level) FROM tiles").Scan(&z) == nil && z.Valid {
+		return int(z.Int64)
+	}
+	return -1
+}
+
+func (m mbTiles) close() {
+	m.db.Close()
+}
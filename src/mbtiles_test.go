@@ -0,0 +1,63 @@
+//go:build mbtiles
+// +build mbtiles
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// test reading tiles and the max zoom from an MBTiles file
+func TestMBTiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "t.mbtiles")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := fillTiles(color.RGBA{0, 0, 255, 255}).tile(0, 0, 0)
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	for _, q := range []string{
+		"CREATE TABLE metadata (name text, value text)",
+		"CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+		"INSERT INTO metadata VALUES ('maxzoom', '4')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// xyz 3/1/2 is tms row 8-1-2
+	if _, err := db.Exec("INSERT INTO tiles VALUES (3, 1, 5, ?)", buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	src, err := openTiles(path)
+	if err != nil {
+		t.Fatalf("openTiles error: %v", err)
+	}
+	defer src.close()
+	if _, err := src.tile(3, 1, 2); err != nil {
+		t.Errorf("mbTiles expected 3/1/2 received %v", err)
+	}
+	if _, err := src.tile(3, 1, 5); err == nil {
+		t.Error("mbTiles expected rows flipped from tms")
+	}
+	if z := src.maxZoom(); z != 4 {
+		t.Errorf("mbTiles expected max zoom 4 received %d", z)
+	}
+
+}
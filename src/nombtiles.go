@@ -0,0 +1,11 @@
+//go:build !mbtiles
+// +build !mbtiles
+
+package main
+
+import "errors"
+
+// MBTiles reading needs the sqlite driver, which needs cgo, so it is left out of default builds
+func openMB(path string) (tileSrc, error) {
+	return nil, errors.New("built without MBTiles support, rebuild with -tags mbtiles (needs cgo) or use a tile dir")
+}
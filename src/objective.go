@@ -0,0 +1,115 @@
+package main
+
+import "math"
+
+// available objectives
+// sum: tour length, bottle: longest leg, minmax: longest crew route
+var objKinds = []string{"sum", "bottle", "minmax"}
+
+// active objective, with the crews and the stop they leave from for minmax
+var (
+	objKind  = "sum"
+	objCrews = 1
+	objHome  point
+)
+
+// bottleneck ties are broken on length, kept well under any leg difference
+const bottleTie = 1e-7
+
+func inObj(inStr string) bool {
+	for _, v := range objKinds {
+		if inStr == v {
+			return true
+		}
+	}
+	return false
+}
+
+// value of the tour on the active objective, lower is better
+func (ps *pnts) objVal() float64 {
+	switch objKind {
+	case "bottle":
+		l, _ := ps.maxLeg()
+		return l + bottleTie*ps.tourLen()
+	case "minmax":
+		_, l := ps.crewSplit(objHome, objCrews)
+		return l
+	}
+	return ps.tourLen()
+}
+
+// longest leg of the closed tour and the index it leaves from
+func (ps *pnts) maxLeg() (float64, int) {
+	t := *ps
+	max, ix := 0.0, 0
+	for i := range t {
+		if d := cost(t[i], t[(i+1)%len(t)]); d > max {
+			max, ix = d, i
+		}
+	}
+	return max, ix
+}
+
+// cut the tour into at most k consecutive crew routes from home, keeping the longest short
+// each route is home then its stops, returns the routes and the longest closed length
+// the cut is a binary search on the longest route, each run taking stops while it fits
+func (ps *pnts) crewSplit(home point, k int) ([]pnts, float64) {
+	const iters = 30
+	t := rotTo(*ps, home)
+	if len(t) == 0 || t[0] != home || len(t) < 2 || k < 2 {
+		return []pnts{t}, t.tourLen()
+	}
+	stops := t[1:]
+	n := len(stops)
+
+	// legs out and back from home and the running path length, so a route is a sum
+	out, back, pre := make([]float64, n), make([]float64, n), make([]float64, n)
+	for i, p := range stops {
+		out[i], back[i] = cost(home, p), cost(p, home)
+		if i > 0 {
+			pre[i] = pre[i-1] + cost(stops[i-1], p)
+		}
+	}
+	route := func(i, j int) float64 {
+		return out[i] + pre[j] - pre[i] + back[j]
+	}
+
+	// runs of stops for longest route lim, nil if more than k are needed
+	runs := func(lim float64) [][2]int {
+		var rs [][2]int
+		for i := 0; i < n; {
+			j := i
+			for j+1 < n && route(i, j+1) <= lim {
+				j++
+			}
+			rs = append(rs, [2]int{i, j})
+			if len(rs) > k {
+				return nil
+			}
+			i = j + 1
+		}
+		return rs
+	}
+
+	lo, hi := 0.0, route(0, n-1)*(1+1e-9)
+	for i := range stops {
+		lo = math.Max(lo, route(i, i))
+	}
+	best := runs(hi)
+	for it := 0; it < iters && hi-lo > 1e-9*hi; it++ {
+		mid := (lo + hi) / 2
+		if r := runs(mid); r != nil {
+			best, hi = r, mid
+		} else {
+			lo = mid
+		}
+	}
+
+	var rts []pnts
+	max := 0.0
+	for _, r := range best {
+		rts = append(rts, append(pnts{home}, stops[r[0]:r[1]+1]...))
+		max = math.Max(max, route(r[0], r[1]))
+	}
+	return rts, max
+}
@@ -88,14 +88,14 @@ func (ps *pnts) sortLab() pnts {
 	return out
 }
 
-// given point, find nearest point from pnts
+// given point, find nearest point from pnts (cost from p)
 func (ps *pnts) nearest(p point, dup bool) (point, int) {
 	min := math.MaxFloat64
 	var best point
 	var index int
 	for i, loc := range *ps {
 		if loc != p || dup { // records assumed distinct
-			h := haver(loc, p)
+			h := cost(p, loc)
 			if h < min {
 				min = h
 				best = loc
@@ -106,13 +106,13 @@ func (ps *pnts) nearest(p point, dup bool) (point, int) {
 	return best, index
 }
 
-// length of tour (km)
+// length of tour (km, or cost matrix units)
 func (ps *pnts) tourLen() float64 {
 	var tourDist float64
 	for i := 0; i < len(*ps)-1; i++ {
-		tourDist += haver((*ps)[i], (*ps)[i+1])
+		tourDist += cost((*ps)[i], (*ps)[i+1])
 	}
-	tourDist += haver((*ps)[len(*ps)-1], (*ps)[0])
+	tourDist += cost((*ps)[len(*ps)-1], (*ps)[0])
 
 	return tourDist
 }
@@ -121,9 +121,9 @@ func (ps *pnts) tourLen() float64 {
 func (ps *pnts) oTourLen(ord []int) float64 {
 	var tourDist float64
 	for i := 0; i < len(*ps)-1; i++ {
-		tourDist += haver((*ps)[ord[i]], (*ps)[ord[i+1]])
+		tourDist += cost((*ps)[ord[i]], (*ps)[ord[i+1]])
 	}
-	tourDist += haver((*ps)[ord[len(ord)-1]], (*ps)[ord[0]])
+	tourDist += cost((*ps)[ord[len(ord)-1]], (*ps)[ord[0]])
 
 	return tourDist
 }
@@ -139,6 +139,10 @@ func (ps *pnts) centPnt() (point, float64) {
 	const floatErrorMax = 1e-6
 	var xb, yb, zb float64
 
+	if len(*ps) == 0 {
+		return point{}, 0
+	}
+
 	for _, loc := range *ps {
 		tmp := loc.polToCart()
 		xb += tmp.x
@@ -158,7 +162,7 @@ func (ps *pnts) centPnt() (point, float64) {
 	minDist := func(c point, p pnts) float64 {
 		var sum float64
 		for _, loc := range p {
-			sum += haver(c, loc)
+			sum += geoDist(c, loc)
 		}
 		return sum
 	}(ctr, *ps)
@@ -166,7 +170,7 @@ func (ps *pnts) centPnt() (point, float64) {
 	sumDistCh := func(c point, p pnts, ch chan<- pntDist, d chan<- bool) {
 		var sum float64
 		for _, loc := range p {
-			sum += haver(c, loc)
+			sum += geoDist(c, loc)
 		}
 		ch <- pntDist{c, sum}
 		d <- true
@@ -273,9 +277,19 @@ func (ps *pnts) nna(start int) pnts {
 	copy(q, *ps)
 	q.rem(start) // remove starting point
 
+	// with precedence or locks only stops that keep them are candidates
+	done := map[point]bool{ordPnts[0]: true}
+
 	cnt := len(q)
 	for i := 0; i < cnt; i++ {
-		p, ix := q.nearest(ordPnts[len(ordPnts)-1], false)
+		var p point
+		var ix int
+		if prec != nil || lock != nil {
+			p, ix = nextStop(q, ordPnts, done)
+			done[p] = true
+		} else {
+			p, ix = q.nearest(ordPnts[len(ordPnts)-1], false)
+		}
 		ordPnts = append(ordPnts, p)
 		q.rem(ix)
 
@@ -290,11 +304,11 @@ func (ps *pnts) nnaMul() pnts {
 
 	res := make(pnts, len(*ps))
 	copy(res, *ps)
-	min := ps.tourLen()
+	min := ps.objVal()
 
 	for i := 0; i < len(*ps); i++ {
 		iter := ps.nna(i)
-		tl := iter.tourLen()
+		tl := iter.objVal()
 		if tl < min {
 			res = iter
 			min = tl
@@ -304,11 +318,42 @@ func (ps *pnts) nnaMul() pnts {
 	return res
 }
 
+// pick optimizer by size using the auto cutoffs, no output
+// used where many tours are built at once
+func (ps *pnts) autoRoute(start int, rate float64) pnts {
+	asym := ps.isAsym()
+
+	switch cnt := len(*ps); {
+	case cnt < 4:
+		return append(pnts{}, *ps...)
+	case cnt < 11:
+		return ps.exh()
+	case asym && cnt <= maxHK:
+		return ps.heldKarp()
+	case asym:
+		nn := ps.nna(start)
+		return nn.atspOpt(200)
+	case cnt <= 750:
+		return ps.opt2SA(rate, false, -1, true)
+	case cnt <= 3000:
+		nn := ps.nna(start)
+		return nn.opt2SA(rate, true, -1, true)
+	case cnt <= 10000:
+		nn := ps.nna(start)
+		return nn.opt2SA(rate, true, 1, false)
+	default:
+		return ps.nna(start)
+	}
+}
+
 // exhaustive search ## don't use > 11 nodes! ##
 func (ps *pnts) exh() pnts {
 	bestOrd := make([]int, len(*ps))
 	outPnts := make(pnts, len(*ps))
-	minTour := (*ps).tourLen()
+	minTour := (*ps).objVal()
+	if !feasible(*ps) {
+		minTour = math.Inf(1)
+	}
 	ord := make([]int, len(*ps))
 	for i := 0; i < len(*ps); i++ {
 		ord[i] = i
@@ -317,7 +362,11 @@ func (ps *pnts) exh() pnts {
 	copy(bestOrd, ord)
 	for n := ord; n != nil; n = nextPerm(n) {
 		t := ps.oTourLen(n)
-		if t < minTour {
+		if objKind != "sum" {
+			o := ps.byOrd(n)
+			t = o.objVal()
+		}
+		if t < minTour && feasible(ps.byOrd(n)) {
 			minTour = t
 			bestOrd = n
 		}
@@ -336,7 +385,7 @@ func (ps *pnts) opt2SA(rate float64, big bool, lim int, sa bool) pnts {
 
 	// set starting values
 	stTour := append(pnts{}, *ps...) // use given order to start
-	stLen := stTour.tourLen()
+	stLen := stTour.objVal()
 	bestLen := stLen
 	bestTour := append(pnts{}, stTour...)
 
@@ -368,7 +417,10 @@ func (ps *pnts) opt2SA(rate float64, big bool, lim int, sa bool) pnts {
 
 				// perform swap
 				tmp := bestTour.optSwap(i, j)
-				len := tmp.tourLen()
+				if !feasible(tmp) {
+					continue
+				}
+				len := tmp.objVal()
 
 				if len < bestLen {
 					bestLen = len
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// orienteering (prize collecting), the most score a closed tour from stop s can collect within bud
+// stops are added by best score per added length, the tour is re-optimized with autoRoute
+// after each round of adds, then stops are dropped and the gap refilled with cheap inserts while
+// the score improves, re-optimizing every few drops and once more at the end
+// score nil counts every stop as 1, stops scoring 0 or less are never visited
+// https://en.wikipedia.org/wiki/Orienteering_problem
+func (ps *pnts) orient(s int, bud float64, score map[point]float64, rate float64) (pnts, pnts) {
+	const (
+		dropTry = 20  // stops tried for removal per pass
+		dropMax = 200 // drop passes before giving up
+		reRoute = 10  // drops between re-optimizing
+	)
+
+	val := func(p point) float64 {
+		if score == nil {
+			return 1
+		}
+		return score[p]
+	}
+	sum := func(t pnts) float64 {
+		var v float64
+		for _, p := range t {
+			v += val(p)
+		}
+		return v
+	}
+
+	home := (*ps)[s]
+	tour := pnts{home}
+	var left pnts
+	for i, p := range *ps {
+		if i != s {
+			left = append(left, p)
+		}
+	}
+
+	// add and re-route until nothing fits
+	grow := func(t, l pnts) (pnts, pnts) {
+		tl := t.tourLen()
+		for {
+			var got float64
+			t, l, tl, got = fillTour(t, l, tl, bud, val)
+			if got == 0 {
+				return t, l
+			}
+			if opt := t.autoRoute(0, rate); opt.tourLen() < tl {
+				t, tl = rotTo(opt, home), opt.tourLen()
+			}
+		}
+	}
+	// re-route a tour built by cheap inserts, then fill any room it opens
+	tidy := func(t, l pnts) (pnts, pnts) {
+		if opt := t.autoRoute(0, rate); opt.tourLen() < t.tourLen() {
+			t = rotTo(opt, home)
+		}
+		return grow(t, l)
+	}
+	tour, left = grow(tour, left)
+
+	// drop and refill, each removal priced on its neighbors
+	tl := tour.tourLen()
+	saved := func(i int) float64 {
+		prev, next := tour[i-1], tour[(i+1)%len(tour)]
+		return cost(prev, tour[i]) + cost(tour[i], next) - cost(prev, next)
+	}
+	dirty := false
+	for n := 1; n <= dropMax; n++ {
+		// least score per length saved first
+		ix := make([]int, 0, len(tour)-1)
+		for i := 1; i < len(tour); i++ {
+			ix = append(ix, i)
+		}
+		ratio := func(i int) float64 {
+			if save := saved(i); save > 0 {
+				return val(tour[i]) / save
+			}
+			return math.Inf(1)
+		}
+		sort.SliceStable(ix, func(a, b int) bool { return ratio(ix[a]) < ratio(ix[b]) })
+		if len(ix) > dropTry {
+			ix = ix[:dropTry]
+		}
+
+		upd := false
+		for _, i := range ix {
+			t := append(append(pnts{}, tour[:i]...), tour[i+1:]...)
+			t, l, ntl, got := fillTour(t, append(pnts{}, left...), tl-saved(i), bud, val) // the dropped stop can't come straight back
+			if got > val(tour[i]) {
+				tour, left, tl = t, append(l, tour[i]), ntl
+				upd, dirty = true, true
+				break
+			}
+		}
+		if upd && n%reRoute != 0 {
+			continue
+		}
+
+		was := sum(tour)
+		tour, left = tidy(tour, left)
+		tl, dirty = tour.tourLen(), false
+		if !upd && sum(tour) <= was {
+			break
+		}
+	}
+	if dirty {
+		tour, left = tidy(tour, left)
+	}
+
+	return tour, left
+}
+
+// cheapest insert of the best score per added length stop, repeated while any fits the budget
+// tl is the length of t, returns the tour, the stops left, its length and the score added
+func fillTour(t, left pnts, tl, bud float64, val func(point) float64) (pnts, pnts, float64, float64) {
+	const eps = 1e-9
+	var got float64
+
+	for {
+		best, bestPos := -1, 0
+		bestRat, bestAdd := 0.0, 0.0
+		for j, p := range left {
+			v := val(p)
+			if v <= 0 {
+				continue
+			}
+			add, pos := insCost(t, p)
+			if tl+add > bud+eps {
+				continue
+			}
+			rat := v / math.Max(add, eps)
+			if best == -1 || rat > bestRat {
+				best, bestPos, bestRat, bestAdd = j, pos, rat, add
+			}
+		}
+		if best == -1 {
+			return t, left, tl, got
+		}
+
+		got += val(left[best])
+		t = append(t[:bestPos+1], append(pnts{left[best]}, t[bestPos+1:]...)...)
+		left = append(left[:best], left[best+1:]...)
+		tl += bestAdd
+	}
+}
+
+// cheapest added length to put p in the closed tour t, and the index it goes after
+func insCost(t pnts, p point) (float64, int) {
+	if len(t) == 1 {
+		return cost(t[0], p) + cost(p, t[0]), 0
+	}
+	min, pos := math.Inf(1), 0
+	for i := range t {
+		a, b := t[i], t[(i+1)%len(t)]
+		if d := cost(a, p) + cost(p, b) - cost(a, b); d < min {
+			min, pos = d, i
+		}
+	}
+	return min, pos
+}
+
+// copy of the tour starting at p
+func rotTo(t pnts, p point) pnts {
+	for i, v := range t {
+		if v == p {
+			return append(append(pnts{}, t[i:]...), t[:i]...)
+		}
+	}
+	return append(pnts{}, t...)
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// minimal OpenStreetMap PBF reader, only what is needed to build a road graph
+// https://wiki.openstreetmap.org/wiki/PBF_Format
+
+// protobuf wire reader
+// https://developers.google.com/protocol-buffers/docs/encoding
+type pbuf struct {
+	b []byte
+	i int
+}
+
+// next field number and wire type, false at end
+func (p *pbuf) next() (int, int, bool) {
+	if p.i >= len(p.b) {
+		return 0, 0, false
+	}
+	key := p.varint()
+	return int(key >> 3), int(key & 7), true
+}
+
+func (p *pbuf) varint() uint64 {
+	var v uint64
+	for s := uint(0); p.i < len(p.b); s += 7 {
+		c := p.b[p.i]
+		p.i++
+		v |= uint64(c&0x7f) << s
+		if c < 0x80 {
+			break
+		}
+	}
+	return v
+}
+
+// zig-zag signed varint
+func (p *pbuf) svarint() int64 {
+	v := p.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (p *pbuf) bytes() []byte {
+	n := int(p.varint())
+	if p.i+n > len(p.b) {
+		n = len(p.b) - p.i
+	}
+	out := p.b[p.i : p.i+n]
+	p.i += n
+	return out
+}
+
+func (p *pbuf) skip(wt int) {
+	switch wt {
+	case 0:
+		p.varint()
+	case 1:
+		p.i += 8
+	case 2:
+		p.bytes()
+	case 5:
+		p.i += 4
+	default:
+		p.i = len(p.b) // unknown, give up on this message
+	}
+}
+
+// packed repeated signed values, delta coded when delta is true
+func packedS(b []byte, delta bool) []int64 {
+	p := pbuf{b: b}
+	var out []int64
+	var last int64
+	for p.i < len(p.b) {
+		v := p.svarint()
+		if delta {
+			v += last
+			last = v
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// packed repeated unsigned values
+func packedU(b []byte) []uint64 {
+	p := pbuf{b: b}
+	var out []uint64
+	for p.i < len(p.b) {
+		out = append(out, p.varint())
+	}
+	return out
+}
+
+// read each OSMData block in the file and hand it to fn
+func readBlocks(path string, fn func(blk []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var szBuf [4]byte
+	for {
+		if _, err := io.ReadFull(f, szBuf[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		hdr := make([]byte, binary.BigEndian.Uint32(szBuf[:]))
+		if _, err := io.ReadFull(f, hdr); err != nil {
+			return err
+		}
+
+		// BlobHeader
+		var typ string
+		var size int
+		hp := pbuf{b: hdr}
+		for fld, wt, ok := hp.next(); ok; fld, wt, ok = hp.next() {
+			switch {
+			case fld == 1 && wt == 2:
+				typ = string(hp.bytes())
+			case fld == 3 && wt == 0:
+				size = int(hp.varint())
+			default:
+				hp.skip(wt)
+			}
+		}
+
+		blob := make([]byte, size)
+		if _, err := io.ReadFull(f, blob); err != nil {
+			return err
+		}
+		if typ != "OSMData" {
+			continue
+		}
+
+		// Blob
+		var data []byte
+		bp := pbuf{b: blob}
+		for fld, wt, ok := bp.next(); ok; fld, wt, ok = bp.next() {
+			switch {
+			case fld == 1 && wt == 2: // raw
+				data = bp.bytes()
+			case fld == 3 && wt == 2: // zlib_data
+				zr, err := zlib.NewReader(bytes.NewReader(bp.bytes()))
+				if err != nil {
+					return err
+				}
+				data, err = ioutil.ReadAll(zr)
+				zr.Close()
+				if err != nil {
+					return err
+				}
+			case fld == 4 && wt == 2:
+				return errors.New("lzma compressed blocks are not supported")
+			default:
+				bp.skip(wt)
+			}
+		}
+
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+}
+
+// a routable way from the extract
+type osmWay struct {
+	refs []int64
+	dir  int     // 0 both ways, 1 forward only, -1 reverse only
+	kph  float64 // travel speed
+}
+
+// default speeds (km/h) for drivable highway classes
+var roadKPH = map[string]float64{
+	"motorway":       100,
+	"motorway_link":  60,
+	"trunk":          80,
+	"trunk_link":     50,
+	"primary":        65,
+	"primary_link":   45,
+	"secondary":      55,
+	"secondary_link": 40,
+	"tertiary":       45,
+	"tertiary_link":  35,
+	"unclassified":   35,
+	"residential":    30,
+	"living_street":  10,
+	"service":        20,
+	"road":           30,
+}
+
+// decode a PrimitiveBlock, calling node for each node and way for each routable way
+func parseBlock(blk []byte, node func(id int64, lat, lon float64), way func(w osmWay)) {
+	var strs [][]byte
+	var groups [][]byte
+	gran, latOff, lonOff := int64(100), int64(0), int64(0)
+
+	p := pbuf{b: blk}
+	for fld, wt, ok := p.next(); ok; fld, wt, ok = p.next() {
+		switch {
+		case fld == 1 && wt == 2:
+			sp := pbuf{b: p.bytes()}
+			for f, w, ok := sp.next(); ok; f, w, ok = sp.next() {
+				if f == 1 && w == 2 {
+					strs = append(strs, sp.bytes())
+				} else {
+					sp.skip(w)
+				}
+			}
+		case fld == 2 && wt == 2:
+			groups = append(groups, p.bytes())
+		case fld == 17 && wt == 0:
+			gran = int64(p.varint())
+		case fld == 19 && wt == 0:
+			latOff = int64(p.varint())
+		case fld == 20 && wt == 0:
+			lonOff = int64(p.varint())
+		default:
+			p.skip(wt)
+		}
+	}
+
+	coord := func(off, v int64) float64 {
+		return 1e-9 * float64(off+gran*v)
+	}
+	str := func(i uint64) string {
+		if int(i) < len(strs) {
+			return string(strs[i])
+		}
+		return ""
+	}
+
+	for _, g := range groups {
+		gp := pbuf{b: g}
+		for fld, wt, ok := gp.next(); ok; fld, wt, ok = gp.next() {
+			if wt != 2 {
+				gp.skip(wt)
+				continue
+			}
+			msg := gp.bytes()
+
+			switch fld {
+			case 1: // Node
+				if node == nil {
+					continue
+				}
+				var id, lat, lon int64
+				np := pbuf{b: msg}
+				for f, w, ok := np.next(); ok; f, w, ok = np.next() {
+					switch {
+					case f == 1 && w == 0:
+						id = np.svarint()
+					case f == 8 && w == 0:
+						lat = np.svarint()
+					case f == 9 && w == 0:
+						lon = np.svarint()
+					default:
+						np.skip(w)
+					}
+				}
+				node(id, coord(latOff, lat), coord(lonOff, lon))
+
+			case 2: // DenseNodes
+				if node == nil {
+					continue
+				}
+				var ids, lats, lons []int64
+				dp := pbuf{b: msg}
+				for f, w, ok := dp.next(); ok; f, w, ok = dp.next() {
+					switch {
+					case f == 1 && w == 2:
+						ids = packedS(dp.bytes(), true)
+					case f == 8 && w == 2:
+						lats = packedS(dp.bytes(), true)
+					case f == 9 && w == 2:
+						lons = packedS(dp.bytes(), true)
+					default:
+						dp.skip(w)
+					}
+				}
+				for i := range ids {
+					if i < len(lats) && i < len(lons) {
+						node(ids[i], coord(latOff, lats[i]), coord(lonOff, lons[i]))
+					}
+				}
+
+			case 3: // Way
+				if way == nil {
+					continue
+				}
+				var keys, vals []uint64
+				var refs []int64
+				wp := pbuf{b: msg}
+				for f, w, ok := wp.next(); ok; f, w, ok = wp.next() {
+					switch {
+					case f == 2 && w == 2:
+						keys = packedU(wp.bytes())
+					case f == 3 && w == 2:
+						vals = packedU(wp.bytes())
+					case f == 8 && w == 2:
+						refs = packedS(wp.bytes(), true)
+					default:
+						wp.skip(w)
+					}
+				}
+
+				tags := make(map[string]string, len(keys))
+				for i := range keys {
+					if i < len(vals) {
+						tags[str(keys[i])] = str(vals[i])
+					}
+				}
+				if w, ok := roadWay(tags); ok && len(refs) > 1 {
+					w.refs = refs
+					way(w)
+				}
+			}
+		}
+	}
+}
+
+// speed and direction of a drivable way, false if not drivable
+func roadWay(tags map[string]string) (osmWay, bool) {
+	kph, ok := roadKPH[tags["highway"]]
+	if !ok {
+		return osmWay{}, false
+	}
+	if tags["access"] == "no" || tags["access"] == "private" || tags["motor_vehicle"] == "no" {
+		return osmWay{}, false
+	}
+
+	if ms := tags["maxspeed"]; ms != "" {
+		fs := strings.Fields(ms)
+		if v, err := strconv.ParseFloat(fs[0], 64); err == nil && v > 0 {
+			if len(fs) > 1 && fs[1] == "mph" {
+				v *= 1.609344
+			}
+			kph = v
+		}
+	}
+
+	var w osmWay
+	w.kph = kph
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		w.dir = 1
+	case "-1", "reverse":
+		w.dir = -1
+	case "no", "false", "0":
+		w.dir = 0
+	default:
+		if tags["highway"] == "motorway" || tags["junction"] == "roundabout" {
+			w.dir = 1
+		}
+	}
+	return w, true
+}
+
+// a directed road segment
+type edge struct {
+	to int
+	km float64
+	hr float64
+}
+
+// routable road network
+type roadGraph struct {
+	lat, lon []float64
+	adj      [][]edge
+	grid     map[[2]int][]int // snapping index
+}
+
+// build the drivable road graph from a PBF extract
+// two passes: ways first to learn which nodes are needed, then their coords
+func loadOSM(path string) (*roadGraph, error) {
+
+	var ways []osmWay
+	need := make(map[int64]int)
+	err := readBlocks(path, func(blk []byte) error {
+		parseBlock(blk, nil, func(w osmWay) {
+			ways = append(ways, w)
+			for _, r := range w.refs {
+				need[r] = -1
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ways) == 0 {
+		return nil, errors.New("no drivable roads in " + path)
+	}
+
+	g := &roadGraph{}
+	err = readBlocks(path, func(blk []byte) error {
+		parseBlock(blk, func(id int64, lat, lon float64) {
+			if ix, ok := need[id]; ok && ix == -1 {
+				need[id] = len(g.lat)
+				g.lat = append(g.lat, lat)
+				g.lon = append(g.lon, lon)
+			}
+		}, nil)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	g.adj = make([][]edge, len(g.lat))
+	for _, w := range ways {
+		for i := 0; i < len(w.refs)-1; i++ {
+			a, b := need[w.refs[i]], need[w.refs[i+1]]
+			if a < 0 || b < 0 { // node outside the extract
+				continue
+			}
+			km := geoDist(g.pnt(a), g.pnt(b))
+			hr := km / w.kph
+			if w.dir >= 0 {
+				g.adj[a] = append(g.adj[a], edge{b, km, hr})
+			}
+			if w.dir <= 0 {
+				g.adj[b] = append(g.adj[b], edge{a, km, hr})
+			}
+		}
+	}
+
+	g.index()
+	fmt.Printf("road graph: %d ways, %d nodes\n", len(ways), len(g.lat))
+	return g, nil
+}
+
+func (g *roadGraph) pnt(i int) point {
+	return point{g.lat[i], g.lon[i], ""}
+}
+
+// grid cells of about 1km
+const gridDeg = 0.01
+
+func gridCell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / gridDeg)), int(math.Floor(lon / gridDeg))}
+}
+
+// least ground distance (km) from a point at lat to ring r of cells around its own
+// r-1 whole cells lie between, measured on lon, the narrower axis, at the ring's far edge
+// kept a little short so a distance model off the sphere can't skip a nearer node
+func ringDist(lat float64, r int) float64 {
+	if r < 2 {
+		return 0
+	}
+	far := math.Min(math.Abs(lat)+float64(r+1)*gridDeg, 90)
+	return float64(r-1) * gridDeg * meanR * math.Pi / 180 * math.Cos(far*math.Pi/180) * 0.99
+}
+
+// bucket nodes on a road edge into grid cells
+func (g *roadGraph) index() {
+	used := make([]bool, len(g.lat))
+	for i, es := range g.adj {
+		for _, e := range es {
+			used[i], used[e.to] = true, true
+		}
+	}
+
+	g.grid = make(map[[2]int][]int)
+	for i := range g.lat {
+		if !used[i] {
+			continue
+		}
+		c := gridCell(g.lat[i], g.lon[i])
+		g.grid[c] = append(g.grid[c], i)
+	}
+}
+
+// nearest road node to p and its distance (km)
+// search rings of cells outward until the next ring can't hold anything nearer
+func (g *roadGraph) snap(p point) (int, float64) {
+	best, min := -1, 0.0
+	c := gridCell(p.lat, p.lon)
+	for r := 0; r <= 500; r++ { // ~500km, nothing near
+		if best != -1 && ringDist(p.lat, r) > min {
+			break
+		}
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				if dy != -r && dy != r && dx != -r && dx != r {
+					continue // inside ring, already done
+				}
+				for _, i := range g.grid[[2]int{c[0] + dy, c[1] + dx}] {
+					if d := geoDist(p, g.pnt(i)); best == -1 || d < min {
+						best, min = i, d
+					}
+				}
+			}
+		}
+	}
+	return best, min
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// one ordering rule, a is visited before b
+type precCon struct {
+	a, b point
+	kind string // "pair" (pickup, delivery) or "after"
+}
+
+// precedence constraints on a tour
+// order is counted from home, the stop the route starts and ends at
+type precGraph struct {
+	home   point
+	cons   []precCon
+	before map[point]pnts // stops that must come ahead of the key
+	in     map[point]bool // stops in any rule
+}
+
+// active constraints, nil for none
+var prec *precGraph
+
+// constraints from the pair and after columns (label to value, see readCol)
+// pair: two stops sharing an id, the first in the file is the pickup and the second the delivery
+// after: labels (; separated) that must be visited before the stop
+func newPrec(ps pnts, home point, pair, after map[point]string) (*precGraph, error) {
+	g := &precGraph{home: home, before: make(map[point]pnts), in: make(map[point]bool)}
+
+	labs := make(map[string]point, len(ps))
+	dup := make(map[string]bool)
+	for _, p := range ps {
+		if _, ok := labs[p.lab]; ok {
+			dup[p.lab] = true
+		}
+		labs[p.lab] = p
+	}
+
+	// pairs in file order
+	open := make(map[string]point)
+	done := make(map[string]bool)
+	for _, p := range ps {
+		id := strings.TrimSpace(pair[p])
+		if id == "" {
+			continue
+		}
+		if done[id] {
+			return nil, fmt.Errorf("pair %q has more than two stops", id)
+		}
+		if a, ok := open[id]; ok {
+			g.add(a, p, "pair")
+			delete(open, id)
+			done[id] = true
+			continue
+		}
+		open[id] = p
+	}
+	if len(open) > 0 {
+		var ids []string
+		for id := range open {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return nil, fmt.Errorf("pair %q has no delivery", ids[0])
+	}
+
+	for _, p := range ps {
+		for _, v := range strings.Split(after[p], ";") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			a, ok := labs[v]
+			if !ok {
+				return nil, fmt.Errorf("%s must come after %q, label not found", p.lab, v)
+			}
+			if dup[v] {
+				return nil, fmt.Errorf("%s must come after %q, label is not unique", p.lab, v)
+			}
+			g.add(a, p, "after")
+		}
+	}
+
+	if len(g.before[home]) > 0 {
+		return nil, fmt.Errorf("start %s must come after %s, move the start", home.lab, g.before[home][0].lab)
+	}
+	if err := g.acyclic(ps); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *precGraph) add(a, b point, kind string) {
+	if a == b {
+		return
+	}
+	g.cons = append(g.cons, precCon{a, b, kind})
+	g.before[b] = append(g.before[b], a)
+	g.in[a], g.in[b] = true, true
+}
+
+// error if the rules loop (a before b before a), no order could satisfy them
+// https://en.wikipedia.org/wiki/Topological_sorting
+func (g *precGraph) acyclic(ps pnts) error {
+	in := make(map[point]int)
+	next := make(map[point]pnts)
+	for _, c := range g.cons {
+		in[c.b]++
+		next[c.a] = append(next[c.a], c.b)
+	}
+	var q pnts
+	for _, p := range ps {
+		if in[p] == 0 {
+			q = append(q, p)
+		}
+	}
+	seen := 0
+	for len(q) > 0 {
+		p := q[0]
+		q = q[1:]
+		seen++
+		for _, n := range next[p] {
+			if in[n]--; in[n] == 0 {
+				q = append(q, n)
+			}
+		}
+	}
+	if seen < len(ps) {
+		return errors.New("precedence rules form a loop")
+	}
+	return nil
+}
+
+// position of each constrained stop counted from home, false if home is not in t
+func (g *precGraph) pos(t pnts) (map[point]int, bool) {
+	h := -1
+	for i, p := range t {
+		if p == g.home {
+			h = i
+			break
+		}
+	}
+	if h == -1 {
+		return nil, false
+	}
+	pos := make(map[point]int, len(g.in))
+	for i, p := range t {
+		if g.in[p] {
+			pos[p] = (i - h + len(t)) % len(t)
+		}
+	}
+	return pos, true
+}
+
+// true if the closed tour t meets every rule
+func (g *precGraph) ok(t pnts) bool {
+	pos, ok := g.pos(t)
+	if !ok {
+		return false
+	}
+	for _, c := range g.cons {
+		pa, okA := pos[c.a]
+		pb, okB := pos[c.b]
+		if okA && okB && pa > pb {
+			return false
+		}
+	}
+	return true
+}
+
+// true if every stop p must follow is in done
+func (g *precGraph) ready(p point, done map[point]bool) bool {
+	for _, a := range g.before[p] {
+		if !done[a] {
+			return false
+		}
+	}
+	return true
+}
+
+// rules that hold the tour back, relaxing one would let a single stop move to shorten the route
+// returns the length saved for each rule, 0 if it is not binding
+func (g *precGraph) binding(t pnts) []float64 {
+	const eps = 1e-9
+	out := make([]float64, len(g.cons))
+	t = rotTo(t, g.home)
+	tl := t.tourLen()
+
+	at := make(map[point]int, len(t))
+	for i, p := range t {
+		at[p] = i
+	}
+
+	// best length moving t[i] to sit after position j, for j in [lo, hi]
+	move := func(i, lo, hi int) float64 {
+		best := tl
+		rest := append(append(pnts{}, t[:i]...), t[i+1:]...)
+		for j := lo; j <= hi; j++ {
+			c := append(append(append(pnts{}, rest[:j+1]...), t[i]), rest[j+1:]...)
+			if l := c.tourLen(); l < best {
+				best = l
+			}
+		}
+		return best
+	}
+
+	for k, c := range g.cons {
+		ia, ib := at[c.a], at[c.b]
+		// b ahead of a, or a behind b (home stays first)
+		best := move(ib, 0, ia-1)
+		if ia > 0 {
+			best = math.Min(best, move(ia, ib-1, len(t)-2))
+		}
+		if tl-best > eps {
+			out[k] = tl - best
+		}
+	}
+	return out
+}
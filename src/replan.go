@@ -0,0 +1,192 @@
+package main
+
+import "sort"
+
+// stops matched by label against a previous plan
+type replan struct {
+	old   pnts          // previous tour, stops still in the input (input coords)
+	added pnts          // input stops not in the previous tour
+	gone  pnts          // previous stops not in the input
+	was   map[point]int // previous position (0 based, in the file) of kept stops
+	legs  map[[2]point]bool
+}
+
+// reconcile the previous tour with the new stop list by label
+func newReplan(prev, ps pnts) *replan {
+	r := &replan{was: make(map[point]int), legs: make(map[[2]point]bool)}
+	labs := make(map[string]point, len(ps))
+	for _, p := range ps {
+		labs[p.lab] = p
+	}
+
+	seen := make(map[string]bool, len(prev))
+	for i, v := range prev {
+		seen[v.lab] = true
+		p, ok := labs[v.lab]
+		if !ok {
+			r.gone = append(r.gone, v)
+			continue
+		}
+		r.was[p] = i
+		r.old = append(r.old, p)
+	}
+	for _, p := range ps {
+		if !seen[p.lab] {
+			r.added = append(r.added, p)
+		}
+	}
+
+	// legs of the previous order, either direction
+	for i, p := range r.old {
+		q := r.old[(i+1)%len(r.old)]
+		r.legs[[2]point{p, q}], r.legs[[2]point{q, p}] = true, true
+	}
+	return r
+}
+
+// length plus w for each changed leg
+func (r *replan) score(t pnts, w float64) float64 {
+	return t.tourLen() + w*float64(r.changed(t))
+}
+
+// legs between kept stops (skipping added ones) not in the previous order
+// added stops change nothing wherever they go
+func (r *replan) changed(t pnts) int {
+	var kept pnts
+	for _, p := range t {
+		if _, ok := r.was[p]; ok {
+			kept = append(kept, p)
+		}
+	}
+	n := 0
+	for i, p := range kept {
+		if !r.legs[[2]point{p, kept[(i+1)%len(kept)]}] {
+			n++
+		}
+	}
+	return n
+}
+
+// re-optimize from the previous order with new stops at their cheapest positions
+// 2-opt and single stop moves that shorten the tour are kept while they lower length plus w per changed leg
+// the previous start stays first, the tour runs the previous direction
+func (r *replan) route(w float64) pnts {
+	const eps = 1e-9
+	const maxPass = 100
+
+	t, _ := insStops(r.old, r.added)
+	if len(r.old) == 0 {
+		t = append(pnts{}, r.added...)
+	}
+	if len(t) < 4 {
+		return t
+	}
+	n := len(t)
+	cur := r.score(t, w)
+	asym := t.isAsym()
+
+	// take c if it lowers the score, the length change d only screens candidates
+	try := func(c pnts) bool {
+		if s := r.score(c, w); s < cur-eps {
+			t, cur = c, s
+			return true
+		}
+		return false
+	}
+
+	for pass, upd := 0, true; upd && pass < maxPass; pass++ {
+		upd = false
+
+		// reverse t[i..j], not on directed costs where the inner legs change too
+		for i := 1; i < n-1 && !asym; i++ {
+			for j := i + 1; j < n; j++ {
+				a, b, c, d := t[i-1], t[i], t[j], t[(j+1)%n]
+				if dl := cost(a, c) + cost(b, d) - cost(a, b) - cost(c, d); dl < -eps && try(t.optSwap(i, j)) {
+					upd = true
+				}
+			}
+		}
+
+		// move t[i] to follow t[j]
+		for i := 1; i < n; i++ {
+			a, p, b := t[i-1], t[i], t[(i+1)%n]
+			save := cost(a, p) + cost(p, b) - cost(a, b)
+			for j := 0; j < n; j++ {
+				if j == i || j == i-1 {
+					continue
+				}
+				x, y := t[j], t[(j+1)%n]
+				dl := cost(x, p) + cost(p, y) - cost(x, y) - save
+				if dl >= -eps {
+					continue
+				}
+				rest := append(append(pnts{}, t[:i]...), t[i+1:]...)
+				k := j
+				if j > i {
+					k--
+				}
+				if try(append(append(append(pnts{}, rest[:k+1]...), p), rest[k+1:]...)) {
+					upd = true
+					break
+				}
+			}
+		}
+	}
+
+	// run the way the crew knows
+	if len(r.old) > 2 {
+		fwd := 0
+		for i, p := range t {
+			if j, ok := r.was[p]; ok {
+				if k, ok := r.was[t[(i+1)%n]]; ok && k > j {
+					fwd++
+				} else if ok {
+					fwd--
+				}
+			}
+		}
+		// directed costs only flip when it's no longer
+		if f := append(pnts{t[0]}, rev(t[1:])...); fwd < 0 && (!asym || f.tourLen() <= t.tourLen()+eps) {
+			t = f
+		}
+	}
+	return t
+}
+
+// kept stops out of their previous order, the fewest that explain the change
+// the rest follow the longest run that keeps the previous order
+// https://en.wikipedia.org/wiki/Longest_increasing_subsequence
+func (r *replan) moved(t pnts) map[point]bool {
+	var seq pnts
+	for _, p := range t {
+		if _, ok := r.was[p]; ok {
+			seq = append(seq, p)
+		}
+	}
+
+	tail := []int{}               // index in seq ending the best run of each length
+	link := make([]int, len(seq)) // previous index in the run
+	for i, p := range seq {
+		k := sort.Search(len(tail), func(n int) bool { return r.was[seq[tail[n]]] >= r.was[p] })
+		link[i] = -1
+		if k > 0 {
+			link[i] = tail[k-1]
+		}
+		if k == len(tail) {
+			tail = append(tail, i)
+		} else {
+			tail[k] = i
+		}
+	}
+
+	out := make(map[point]bool, len(seq))
+	for _, p := range seq {
+		out[p] = true
+	}
+	if len(tail) > 0 {
+		for i := tail[len(tail)-1]; i != -1; i = link[i] {
+			delete(out, seq[i])
+		}
+	}
+	return out
+}
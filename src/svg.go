@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// write the map as svg on the same mercator view as the png, no basemap
+// lines and stops go in their own groups so they can be picked apart in an editor
+func (d *mapDraw) svg(w io.Writer) error {
+	v := d.view()
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintf(b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", v.w, v.h, v.w, v.h)
+	fmt.Fprintf(b, "<rect width=\"100%%\" height=\"100%%\" fill=\"#f5f5f0\"/>\n")
+
+	fmt.Fprintln(b, "<g id=\"lines\" fill=\"none\" stroke-linecap=\"round\" stroke-linejoin=\"round\">")
+	for _, l := range d.lines {
+		xy := make([]string, len(l.ps))
+		for i, p := range l.ps {
+			x, y := v.px(p)
+			xy[i] = svgNum(x) + "," + svgNum(y)
+		}
+		fmt.Fprintf(b, "<polyline points=\"%s\" stroke=\"%s\" stroke-width=\"%s\"/>\n", strings.Join(xy, " "), hexClr(l.clr), svgNum(l.w))
+	}
+	fmt.Fprintln(b, "</g>")
+
+	fmt.Fprintln(b, "<g id=\"stops\" stroke=\"#ffffff\" font-family=\"sans-serif\">")
+	for _, m := range d.mks {
+		x, y := v.px(m.p)
+		fmt.Fprintf(b, "<circle cx=\"%s\" cy=\"%s\" r=\"%s\" fill=\"%s\">", svgNum(x), svgNum(y), svgNum(m.size/2), hexClr(m.clr))
+		if m.p.lab != "" {
+			fmt.Fprintf(b, "<title>%s</title>", html.EscapeString(m.p.lab))
+		}
+		fmt.Fprintln(b, "</circle>")
+		if m.lab != "" {
+			fs := math.Max(10, m.size*0.8)
+			fmt.Fprintf(b, "<text x=\"%s\" y=\"%s\" font-size=\"%s\" stroke=\"none\" fill=\"#1a1a1a\" dominant-baseline=\"middle\">%s</text>\n",
+				svgNum(x+m.size/2+2), svgNum(y), svgNum(fs), html.EscapeString(m.lab))
+		}
+	}
+	fmt.Fprintln(b, "</g>")
+	fmt.Fprintln(b, "</svg>")
+
+	return b.Flush()
+}
+
+// svg coordinate, a tenth of a pixel is plenty
+func svgNum(f float64) string {
+	return strconv.FormatFloat(math.Round(f*10)/10, 'f', -1, 64)
+}
+
+// #rrggbb
+func hexClr(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // tile formats
+	_ "image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+)
+
+// web mercator tile edge (px)
+const tileSize = 256
+
+// longest wait on online tiles before drawing without a basemap, once per run
+const mapWait = 45 * time.Second
+
+// web mercator world pixel of p at zoom z
+// https://en.wikipedia.org/wiki/Web_Mercator_projection
+func mercPx(p point, z int) (float64, float64) {
+	n := tileSize * math.Exp2(float64(z))
+	lat := math.Max(math.Min(p.lat, 85.05112878), -85.05112878)
+	s := math.Sin(lat * math.Pi / 180)
+	x := (p.lon + 180) / 360 * n
+	y := (0.5 - math.Log((1+s)/(1-s))/(4*math.Pi)) * n
+	return x, y
+}
+
+// a w by h canvas on the world at zoom z, ox, oy is the world pixel at its top left
+type mapView struct {
+	z      int
+	ox, oy float64
+	w, h   int
+}
+
+// the largest zoom showing every point with pad px to spare on each side, centered
+func fitView(ps pnts, w, h int, pad float64) mapView {
+	const maxZoom = 17
+	v := mapView{w: w, h: h}
+	if len(ps) == 0 {
+		return v
+	}
+	for z := maxZoom; z >= 0; z-- {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, p := range ps {
+			x, y := mercPx(p, z)
+			minX, minY = math.Min(minX, x), math.Min(minY, y)
+			maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+		}
+		if z == 0 || maxX-minX <= float64(w)-2*pad && maxY-minY <= float64(h)-2*pad {
+			v.z = z
+			v.ox, v.oy = (minX+maxX-float64(w))/2, (minY+maxY-float64(h))/2
+			return v
+		}
+	}
+	return v
+}
+
+// canvas pixel of p
+func (v mapView) px(p point) (float64, float64) {
+	x, y := mercPx(p, v.z)
+	return x - v.ox, y - v.oy
+}
+
+// the style canvas fit to everything drawn, with room for the markers
+func (d *mapDraw) view() mapView {
+	pad := 10.0
+	for _, m := range d.mks {
+		pad = math.Max(pad, m.size+10)
+	}
+	return fitView(d.pnts(), style.w, style.h, pad)
+}
+
+// draw on a plain canvas, over tiles from src unless it is nil
+// past the deepest zoom src holds its tiles are scaled up, missing tiles are left blank
+func (d *mapDraw) plain(src tileSrc) image.Image {
+	v := d.view()
+
+	dc := gg.NewContext(style.w, style.h)
+	dc.SetRGB(0.96, 0.96, 0.94)
+	dc.Clear()
+	if src != nil {
+		z := v.z
+		if mz := src.maxZoom(); mz >= 0 && mz < z {
+			z = mz
+		}
+		if miss := v.drawTiles(dc, src, z); miss > 0 {
+			fmt.Printf("warning, %d map tiles not in %v\n", miss, *tilePath)
+		}
+	}
+
+	dc.SetLineCapRound()
+	dc.SetLineJoinRound()
+	for _, l := range d.lines {
+		for i, p := range l.ps {
+			x, y := v.px(p)
+			if i == 0 {
+				dc.MoveTo(x, y)
+			} else {
+				dc.LineTo(x, y)
+			}
+		}
+		dc.SetColor(l.clr)
+		dc.SetLineWidth(l.w)
+		dc.Stroke()
+	}
+
+	for _, m := range d.mks {
+		x, y := v.px(m.p)
+		dc.DrawCircle(x, y, m.size/2)
+		dc.SetColor(m.clr)
+		dc.FillPreserve()
+		dc.SetColor(color.White)
+		dc.SetLineWidth(1)
+		dc.Stroke()
+		if m.lab != "" {
+			dc.SetRGB(0.1, 0.1, 0.1)
+			dc.DrawStringAnchored(m.lab, x+m.size/2+2, y, 0, 0.35)
+		}
+	}
+	return dc.Image()
+}
+
+// draw the zoom z tiles under the view (z at most the view's, scaled up to it)
+// returns how many src did not have
+func (v mapView) drawTiles(dc *gg.Context, src tileSrc, z int) int {
+	n := 1 << uint(z)
+	k := math.Exp2(float64(v.z - z))
+	ts := tileSize * k // tile edge on the canvas
+	miss := 0
+	for ty := int(math.Floor(v.oy / ts)); float64(ty)*ts < v.oy+float64(v.h); ty++ {
+		if ty < 0 || ty >= n {
+			continue
+		}
+		for tx := int(math.Floor(v.ox / ts)); float64(tx)*ts < v.ox+float64(v.w); tx++ {
+			img, err := src.tile(z, (tx%n+n)%n, ty)
+			if err != nil {
+				miss++
+				continue
+			}
+			x, y := float64(tx)*ts-v.ox, float64(ty)*ts-v.oy
+			if k == 1 {
+				dc.DrawImage(img, int(math.Round(x)), int(math.Round(y)))
+				continue
+			}
+			dc.Push()
+			dc.Translate(x, y)
+			dc.Scale(k, k)
+			dc.DrawImage(img, 0, 0)
+			dc.Pop()
+		}
+	}
+	return miss
+}
+
+// local map tiles by zoom, column and row (rows from the north)
+type tileSrc interface {
+	tile(z, x, y int) (image.Image, error)
+	maxZoom() int // deepest zoom held, -1 if unknown
+	close()
+}
+
+// z/x/y.png (or .jpg) files under a dir, the layout prefetch writes
+type tileDir string
+
+func (t tileDir) tile(z, x, y int) (image.Image, error) {
+	for _, ext := range []string{".png", ".jpg", ".jpeg"} {
+		f, err := os.Open(filepath.Join(string(t), strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+ext))
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		return img, err
+	}
+	return nil, os.ErrNotExist
+}
+
+// highest numbered zoom dir
+func (t tileDir) maxZoom() int {
+	max := -1
+	fis, _ := ioutil.ReadDir(string(t))
+	for _, fi := range fis {
+		if z, err := strconv.Atoi(fi.Name()); err == nil && fi.IsDir() && z > max {
+			max = z
+		}
+	}
+	return max
+}
+
+func (t tileDir) close() {}
+
+// tile dir or .mbtiles file
+func openTiles(path string) (tileSrc, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".mbtiles") {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		return openMB(path)
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("tile dir %q not found", path)
+	}
+	return tileDir(path), nil
+}
+
+// tile holding p at zoom z
+func tileXY(p point, z int) (int, int) {
+	n := 1 << uint(z)
+	x, y := mercPx(p, z)
+	clamp := func(v float64) int {
+		return int(math.Max(0, math.Min(float64(n-1), math.Floor(v/tileSize))))
+	}
+	return clamp(x), clamp(y)
+}
+
+// download the tiles covering the box lo (south west) to hi (north east) at zooms z0 to z1 into dir
+// pattern has {z}, {x} and {y}, tiles already in dir are skipped
+// returns tiles fetched and skipped, stops at the first failed tile
+func prefetch(dir, pattern string, lo, hi point, z0, z1 int) (int, int, error) {
+	const maxTiles = 10000
+
+	type tile struct{ z, x, y int }
+	var all []tile
+	for z := z0; z <= z1; z++ {
+		x0, y0 := tileXY(point{lat: hi.lat, lon: lo.lon}, z)
+		x1, y1 := tileXY(point{lat: lo.lat, lon: hi.lon}, z)
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				all = append(all, tile{z, x, y})
+			}
+		}
+		if len(all) > maxTiles {
+			return 0, 0, fmt.Errorf("more than %d tiles, use a smaller box or zoom range", maxTiles)
+		}
+	}
+
+	cl := &http.Client{Timeout: 30 * time.Second}
+	got, skip := 0, 0
+	for _, t := range all {
+		zs, xs, ys := strconv.Itoa(t.z), strconv.Itoa(t.x), strconv.Itoa(t.y)
+		path := filepath.Join(dir, zs, xs, ys+".png")
+		if _, err := os.Stat(path); err == nil {
+			skip++
+			continue
+		}
+
+		u := strings.NewReplacer("{z}", zs, "{x}", xs, "{y}", ys).Replace(pattern)
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return got, skip, err
+		}
+		req.Header.Set("User-Agent", "tss route planner tile prefetch")
+		resp, err := cl.Do(req)
+		if err != nil {
+			return got, skip, urlErr(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return got, skip, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return got, skip, errors.New("tile " + zs + "/" + xs + "/" + ys + ": " + resp.Status)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return got, skip, err
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			return got, skip, err
+		}
+		got++
+	}
+	return got, skip, nil
+}
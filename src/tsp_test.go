@@ -1,9 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
 	"math"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -570,12 +585,179 @@ func TestKmeans(t *testing.T) {
 		return cnt
 	}
 
-	val := p.kmeans(5)
+	val := p.kmeans(5, 1, 100, 0.001)
 	if count(val) != len(p) {
 		t.Errorf("expected %d vals, and got %d", len(p), count(val))
 	}
 
-	//genClusters(val, "clusterTestPNG")
+	//genClusters(val, nil, "clusterTestPNG")
+
+}
+
+func TestBalAsgn(t *testing.T) {
+	p := pnts{
+		point{0, 0, "A"}, point{0, 0.01, "B"}, point{0.01, 0, "C"}, point{0.01, 0.01, "D"},
+		point{0, 0.02, "E"}, point{1, 1, "F"},
+	}
+	c := []cluster{{ctr: point{0, 0, ""}}, {ctr: point{1, 1, ""}}}
+
+	var cases = []clsLim{
+		{max: 3},
+		{min: 3},
+		{maxDem: 3, dem: map[point]float64{p[0]: 1, p[1]: 1, p[2]: 1, p[3]: 1, p[4]: 1, p[5]: 1}},
+	}
+
+	for _, tst := range cases {
+		val, _ := p.balAsgn(c, tst)
+		if len(val[0].cls) != 3 || len(val[1].cls) != 3 {
+			t.Errorf("balAsgn with %+v, expected sizes 3,3 received %d,%d", tst, len(val[0].cls), len(val[1].cls))
+		}
+		if ov := tst.over(val); len(ov) > 0 {
+			t.Errorf("balAsgn with %+v, clusters %v over limits", tst, ov)
+		}
+	}
+
+}
+
+func TestClsLimCheck(t *testing.T) {
+	p := pnts{point{0, 0, "A"}, point{0, 1, "B"}, point{1, 0, "C"}, point{1, 1, "D"}}
+	var cases = []struct {
+		lim    clsLim
+		k      int
+		errRes bool
+	}{
+		{clsLim{max: 2}, 2, false},
+		{clsLim{max: 1}, 2, true},
+		{clsLim{min: 3}, 2, true},
+		{clsLim{min: 3, max: 2}, 1, true},
+		{clsLim{maxDem: 1, dem: map[point]float64{p[0]: 2}}, 2, true},
+	}
+
+	for _, tst := range cases {
+		err := tst.lim.check(p, tst.k)
+		if (err == nil && tst.errRes) || (err != nil && !tst.errRes) {
+			t.Errorf("check(%+v, %d), expected err=%t received %v", tst.lim, tst.k, tst.errRes, err)
+		}
+	}
+
+}
+
+func TestDbscan(t *testing.T) {
+	p := pnts{
+		point{45.0000, -122.0000, "A"}, point{45.0010, -122.0000, "B"}, point{45.0000, -122.0010, "C"},
+		point{45.1000, -122.1000, "D"}, point{45.1010, -122.1000, "E"}, point{45.1000, -122.1010, "F"},
+		point{46.0000, -121.0000, "G"},
+	}
+
+	val, noise := p.dbscan(0.5, 3)
+	if len(val) != 2 {
+		t.Errorf("dbscan expected 2 clusters, received %d", len(val))
+	}
+	if len(noise) != 1 || noise[0].lab != "G" {
+		t.Errorf("dbscan expected noise [G], received %v", noise)
+	}
+	for _, v := range val {
+		if len(v.cls) != 3 {
+			t.Errorf("dbscan expected clusters of 3, received %v", v.cls)
+		}
+	}
+
+	// a degree of lat is shortest at the equator on the ellipsoid
+	defer func() { distMod = "haver" }()
+	distMod = "vincenty"
+	q := pnts{point{0, 0, "A"}, point{0.01, 0, "B"}}
+	if nb := q.region(geoDist(q[0], q[1])); len(nb[0]) != 1 {
+		t.Errorf("region expected B exactly eps from A, received %v", nb)
+	}
+
+}
+
+func TestRouteCls(t *testing.T) {
+	c := []cluster{
+		{point{0, 0, ""}, pnts{point{0, 0, "A"}, point{0, 1, "B"}, point{1, 1, "C"}, point{1, 0, "D"}, point{0.5, 0.5, "E"}}},
+		{point{5, 5, ""}, pnts{point{5, 5, "F"}, point{5, 6, "G"}}},
+	}
+	depot := point{2, 2, "depot"}
+
+	val := routeCls(c, &depot, 0.8)
+	for i, v := range val {
+		if len(v.cls) != len(c[i].cls) {
+			t.Errorf("routeCls cluster %d, expected %d stops received %d", i, len(c[i].cls), len(v.cls))
+		}
+	}
+	if val[0].cls[0].lab != "C" || val[1].cls[0].lab != "F" {
+		t.Errorf("routeCls expected tours starting at C and F, received %v and %v", val[0].cls[0], val[1].cls[0])
+	}
+
+}
+
+func TestFixedCls(t *testing.T) {
+	p := pnts{
+		point{45.00, -122.00, "A"}, point{45.01, -122.00, "B"}, point{45.00, -122.01, "C"},
+		point{46.00, -121.00, "D"}, point{46.01, -121.00, "E"},
+		point{50.00, -110.00, "F"},
+	}
+	ctrs := pnts{point{45, -122, "North Base"}, point{46, -121, "South Base"}}
+
+	val, left := p.fixedCls(ctrs, false, 100, 0.001, clsLim{rad: 10})
+	if len(val[0].cls) != 3 || len(val[1].cls) != 2 {
+		t.Errorf("fixedCls expected sizes 3,2 received %d,%d", len(val[0].cls), len(val[1].cls))
+	}
+	if len(left) != 1 || left[0].lab != "F" {
+		t.Errorf("fixedCls expected F unassigned, received %v", left)
+	}
+	if val[0].ctr != ctrs[0] || val[1].ctr != ctrs[1] {
+		t.Errorf("fixedCls expected pinned centers %v, received %v", ctrs, getCtrs(val))
+	}
+
+	val, _ = p.fixedCls(ctrs, false, 100, 0.001, clsLim{max: 2, rad: 10})
+	if len(val[0].cls) != 2 {
+		t.Errorf("fixedCls with max 2, received %d stops", len(val[0].cls))
+	}
+
+	val, _ = p.fixedCls(ctrs, true, 100, 0.001, clsLim{})
+	if val[0].ctr.lab != "North Base" || val[0].ctr == ctrs[0] {
+		t.Errorf("fixedCls drift expected labeled moved center, received %v", val[0].ctr)
+	}
+
+	names := clsNames(val, true)
+	if names[0] != "North_Base.txt" {
+		t.Errorf("clsNames expected North_Base.txt, received %s", names[0])
+	}
+	names = clsNames([]cluster{{ctr: point{lab: "noise"}}, {ctr: point{lab: "cls2"}}, {}}, true)
+	if names[0] != "noise_0.txt" || names[1] != "cls2.txt" || names[2] != "cls2_2.txt" {
+		t.Errorf("clsNames expected noise_0.txt, cls2.txt, cls2_2.txt, received %v", names)
+	}
+
+}
+
+func TestHclust(t *testing.T) {
+	p := pnts{
+		point{45.000, -122.000, "A"}, point{45.001, -122.000, "B"}, point{45.000, -122.001, "C"},
+		point{45.100, -122.100, "D"}, point{45.101, -122.100, "E"},
+		point{46.000, -121.000, "F"},
+	}
+
+	for _, lnk := range linkHC {
+		val := p.hclust(lnk, 3, 0)
+		if len(val) != 3 {
+			t.Errorf("hclust(%s, 3) expected 3 clusters, received %d", lnk, len(val))
+			continue
+		}
+		if len(val[0].cls) != 3 || len(val[1].cls) != 2 || len(val[2].cls) != 1 {
+			t.Errorf("hclust(%s, 3) expected sizes 3,2,1 received %d,%d,%d", lnk, len(val[0].cls), len(val[1].cls), len(val[2].cls))
+		}
+	}
+
+	// 1km cut keeps the tight groups only
+	val := p.hclust("complete", 0, 1)
+	if len(val) != 3 {
+		t.Errorf("hclust(complete, 1km) expected 3 clusters, received %d", len(val))
+	}
+	val = p.hclust("single", 0, 0.01)
+	if len(val) != len(p) {
+		t.Errorf("hclust(single, 10m) expected %d clusters, received %d", len(p), len(val))
+	}
 
 }
 
@@ -584,6 +766,50 @@ type pntsInt struct {
 	cnt  int
 }
 
+func TestKppPoints(t *testing.T) {
+	var cases = []pntsInt{
+		{pnts{point{0, 0, "A"}, point{0, 1, "B"}, point{1, 0, "C"}, point{5, 5, "D"}, point{5, 6, "E"}}, 2},
+		{pnts{point{0, 0, "A"}, point{0, 0, "B"}, point{0, 0, "C"}}, 3},
+	}
+
+	for _, tst := range cases {
+		val := tst.vals.kppPoints(tst.cnt)
+		if len(val) != tst.cnt {
+			t.Errorf("kppPoints of %v, expected cnt %v, received %v", tst.vals, tst.cnt, len(val))
+		}
+	}
+
+}
+
+func TestFixEmpty(t *testing.T) {
+	c := []cluster{
+		{point{0, 0, ""}, pnts{point{0, 0, "A"}, point{0, 0.1, "B"}, point{3, 3, "C"}}},
+		{point{9, 9, ""}, pnts{}},
+	}
+
+	val := fixEmpty(c)
+	if len(val[0].cls) != 2 || len(val[1].cls) != 1 {
+		t.Errorf("fixEmpty, expected sizes 2,1 received %d,%d", len(val[0].cls), len(val[1].cls))
+	}
+	if val[1].cls[0].lab != "C" {
+		t.Errorf("fixEmpty, expected farthest point C moved, received %v", val[1].cls[0])
+	}
+
+}
+
+func TestCompCtrs(t *testing.T) {
+	pre := pnts{point{45, -122, ""}, point{46, -122, ""}}
+	cur := pnts{point{45, -122.00001, ""}, point{46, -122, ""}}
+
+	if !compCtrs(pre, cur, 0.01) {
+		t.Errorf("compCtrs(%v, %v) expected within 0.01km", pre, cur)
+	}
+	if compCtrs(pre, cur, 0) {
+		t.Errorf("compCtrs(%v, %v) expected shift > 0km", pre, cur)
+	}
+
+}
+
 func TestCtrAgg(t *testing.T) {
 	var cases = []pntsInt{
 		{pnts{point{0, 0, "A"}}, 1},
@@ -609,3 +835,1269 @@ func TestCtrAgg(t *testing.T) {
 // test opt2SA
 // test saProb
 // test fastDist
+
+// protobuf helpers to build a small PBF for tests
+func pbVar(v uint64) []byte {
+	var b []byte
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func pbLen(fld int, b []byte) []byte {
+	out := append(pbVar(uint64(fld<<3|2)), pbVar(uint64(len(b)))...)
+	return append(out, b...)
+}
+
+func pbInt(fld int, v uint64) []byte {
+	return append(pbVar(uint64(fld<<3)), pbVar(v)...)
+}
+
+func pbPackS(vals []int64) []byte {
+	var b []byte
+	var last int64
+	for _, v := range vals {
+		d := v - last
+		last = v
+		b = append(b, pbVar(uint64((d<<1)^(d>>63)))...)
+	}
+	return b
+}
+
+// write blob with header to the file buffer, zlib when zip
+func pbBlob(buf *bytes.Buffer, typ string, data []byte, zip bool) {
+	var blob []byte
+	if zip {
+		var zb bytes.Buffer
+		zw := zlib.NewWriter(&zb)
+		zw.Write(data)
+		zw.Close()
+		blob = append(pbInt(2, uint64(len(data))), pbLen(3, zb.Bytes())...)
+	} else {
+		blob = pbLen(1, data)
+	}
+	hdr := append(pbLen(1, []byte(typ)), pbInt(3, uint64(len(blob)))...)
+
+	var sz [4]byte
+	binary.BigEndian.PutUint32(sz[:], uint32(len(hdr)))
+	buf.Write(sz[:])
+	buf.Write(hdr)
+	buf.Write(blob)
+}
+
+// four nodes east along lat 45, a two way road 1-2-3 and a one way 3->4
+// plus a footway 1-4 that must be ignored
+func testPBF(t *testing.T) string {
+	strs := [][]byte{{}, []byte("highway"), []byte("residential"), []byte("oneway"), []byte("yes"), []byte("footway")}
+	var st []byte
+	for _, v := range strs {
+		st = append(st, pbLen(1, v)...)
+	}
+
+	lats := []int64{450000000, 450000000, 450000000, 450000000}
+	lons := []int64{-1220000000, -1219900000, -1219800000, -1219700000}
+	dense := append(pbLen(1, pbPackS([]int64{1, 2, 3, 4})), pbLen(8, pbPackS(lats))...)
+	dense = append(dense, pbLen(9, pbPackS(lons))...)
+	nodeBlk := append(pbLen(1, st), pbLen(2, pbLen(2, dense))...)
+
+	way := func(id uint64, refs []int64, keys, vals []byte) []byte {
+		w := pbInt(1, id)
+		w = append(w, pbLen(2, keys)...)
+		w = append(w, pbLen(3, vals)...)
+		return append(w, pbLen(8, pbPackS(refs))...)
+	}
+	grp := pbLen(3, way(10, []int64{1, 2, 3}, []byte{1}, []byte{2}))
+	grp = append(grp, pbLen(3, way(11, []int64{3, 4}, []byte{1, 3}, []byte{2, 4}))...)
+	grp = append(grp, pbLen(3, way(12, []int64{1, 4}, []byte{1}, []byte{5}))...)
+	wayBlk := append(pbLen(1, st), pbLen(2, grp)...)
+
+	var buf bytes.Buffer
+	pbBlob(&buf, "OSMHeader", []byte{}, false)
+	pbBlob(&buf, "OSMData", nodeBlk, true)
+	pbBlob(&buf, "OSMData", wayBlk, false)
+
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.osm.pbf")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOSM(t *testing.T) {
+	path := testPBF(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	g, err := loadOSM(path)
+	if err != nil {
+		t.Fatalf("loadOSM error: %v", err)
+	}
+	if len(g.lat) != 4 {
+		t.Errorf("loadOSM expected 4 nodes, received %d", len(g.lat))
+	}
+
+	// stops sitting on nodes 1 and 4
+	ps := pnts{point{45, -122, "A"}, point{45, -121.97, "B"}}
+	ix, d := g.snap(point{45.0001, -121.97, ""})
+	if math.Abs(g.lon[ix]+121.97) > floatErrorMax || d > 0.02 {
+		t.Errorf("snap expected node 4, received %d at %fkm", ix, d)
+	}
+
+	// up north a cell is far narrower east-west, the nearer node is three rings out that way
+	hg := &roadGraph{lat: []float64{70.0175, 70.005}, lon: []float64{20.005, 20.0366}, adj: [][]edge{{{to: 1}}, nil}}
+	hg.index()
+	if ix, d := hg.snap(point{70.005, 20.005, ""}); ix != 1 {
+		t.Errorf("snap at 70N expected the east node, received %d at %fkm", ix, d)
+	}
+
+	// cells are the same width either side of 0
+	if a, b := gridCell(-0.005, -0.005), gridCell(0.005, 0.005); a != [2]int{-1, -1} || b != [2]int{0, 0} {
+		t.Errorf("gridCell expected cells -1 and 0 about the origin, received %v and %v", a, b)
+	}
+
+	m := g.matrix(ps, "dist")
+	ab := haver(ps[0], ps[1])
+	if math.Abs(m.dist[0][1]-ab) > floatErrorMax {
+		t.Errorf("road A->B expected %f, received %f", ab, m.dist[0][1])
+	}
+	if m.dur[1][0] <= m.dur[0][1] {
+		t.Errorf("road B->A expected slower unreachable fallback, received %f", m.dur[1][0])
+	}
+	if m.dur[0][1] <= 0 || costUnit() != "km" {
+		t.Errorf("road A->B expected time > 0, received %f", m.dur[0][1])
+	}
+
+}
+
+func TestMatrixFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ps := pnts{point{45.5428626, -122.794813, "OR"}, point{42.752916, -71.5669218, "NH"}, point{45.5744697, -122.566121, "PR"}}
+
+	// round trip haversine export
+	for _, nm := range []string{"m.tsv", "m.csv", "m.json"} {
+		path := filepath.Join(dir, nm)
+		if err := writeMatrix(path, ps); err != nil {
+			t.Fatalf("writeMatrix(%s) error: %v", nm, err)
+		}
+		m, err := readMatrix(path, ps, "dist")
+		if err != nil {
+			t.Fatalf("readMatrix(%s) error: %v", nm, err)
+		}
+		for i := range ps {
+			for j := range ps {
+				if math.Abs(m.cost[i][j]-haver(ps[i], ps[j])) > 1e-3 {
+					t.Errorf("readMatrix(%s) [%d][%d] expected %f received %f", nm, i, j, haver(ps[i], ps[j]), m.cost[i][j])
+				}
+			}
+		}
+	}
+
+	// engine table in seconds, labels reorder rows
+	path := filepath.Join(dir, "t.json")
+	tbl := `{"labels":["PR","OR","NH"],"durations":[[0,60,120],[90,0,180],[240,300,0]]}`
+	if err := ioutil.WriteFile(path, []byte(tbl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readMatrix(path, ps, "time")
+	if err != nil {
+		t.Fatalf("readMatrix(t.json) error: %v", err)
+	}
+	if m.cost[2][0] != 1 || m.cost[0][2] != 1.5 || m.unit != "min" {
+		t.Errorf("readMatrix(t.json) expected PR->OR 1min and OR->PR 1.5min, received %f and %f", m.cost[2][0], m.cost[0][2])
+	}
+	if _, err := readMatrix(path, ps, "dist"); err == nil {
+		t.Errorf("readMatrix(t.json) expected error for missing distances")
+	}
+
+	// optimizers use the loaded costs
+	mat = m
+	defer func() { mat = nil }()
+	if tl := ps.tourLen(); math.Abs(tl-(3+4+1)) > floatErrorMax {
+		t.Errorf("tourLen with matrix expected 8 received %f", tl)
+	}
+
+}
+
+// test heldKarp
+func TestHeldKarp(t *testing.T) {
+	ps := pnts{
+		point{45.5428626, -122.794813, "a"}, point{45.5744697, -122.566121, "b"}, point{45.4, -122.6, "c"},
+		point{45.6, -122.7, "d"}, point{45.45, -122.9, "e"}, point{45.52, -122.45, "f"}, point{45.35, -122.75, "g"},
+	}
+
+	// symmetric matches the exhaustive search
+	exh := ps.exh()
+	hk := ps.heldKarp()
+	if len(hk) != len(ps) || math.Abs(hk.tourLen()-exh.tourLen()) > floatErrorMax {
+		t.Errorf("heldKarp expected length %f received %f", exh.tourLen(), hk.tourLen())
+	}
+
+	// directed costs, going a->b->c.. is cheap and the reverse is expensive
+	m := newMatrix(ps)
+	for i := range ps {
+		for j := range ps {
+			if i != j {
+				m.dist[i][j] = 10
+			}
+		}
+		m.dist[i][(i+1)%len(ps)] = 1
+	}
+	mat = m
+	defer func() { mat = nil }()
+
+	if !ps.isAsym() {
+		t.Errorf("isAsym expected true")
+	}
+	if hk := ps.heldKarp(); math.Abs(hk.tourLen()-7) > floatErrorMax {
+		t.Errorf("heldKarp directed expected 7 received %f (%v)", hk.tourLen(), hk)
+	}
+
+}
+
+// test orOpt and or3opt
+func TestAtspOpt(t *testing.T) {
+	n := 12
+	c := make([][]float64, n)
+	for i := range c {
+		c[i] = make([]float64, n)
+		for j := range c[i] {
+			c[i][j] = float64((i*7+j*13)%17 + 1)
+		}
+	}
+	ord := []int{0, 5, 2, 9, 1, 11, 3, 7, 4, 10, 6, 8}
+	pre := ordLen(c, ord)
+
+	out := orOpt(c, append([]int{}, ord...))
+	for upd := true; upd; {
+		out, upd = or3opt(c, out)
+	}
+
+	seen := make(map[int]bool)
+	for _, v := range out {
+		seen[v] = true
+	}
+	if len(out) != n || len(seen) != n {
+		t.Errorf("orOpt/or3opt lost stops: %v", out)
+	}
+	if post := ordLen(c, out); post > pre+floatErrorMax {
+		t.Errorf("orOpt/or3opt increased length %f --> %f", pre, post)
+	}
+
+}
+
+// test engine against mock osrm and valhalla servers
+func TestEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ps := pnts{
+		point{45.5428626, -122.794813, "a"}, point{45.5744697, -122.566121, "b"}, point{45.4, -122.6, "c"},
+		point{45.6, -122.7, "d"}, point{45.45, -122.9, "e"},
+	}
+
+	// osrm, distances are haver in meters and the drive is 60kph
+	hits := 0
+	osrm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		var crd pnts
+		for _, v := range strings.Split(strings.TrimPrefix(r.URL.Path, "/table/v1/driving/"), ";") {
+			ll := strings.Split(v, ",")
+			lon, _ := strconv.ParseFloat(ll[0], 64)
+			lat, _ := strconv.ParseFloat(ll[1], 64)
+			crd = append(crd, point{lat, lon, ""})
+		}
+		ix := func(q string) []int {
+			var out []int
+			for _, v := range strings.Split(r.URL.Query().Get(q), ";") {
+				i, _ := strconv.Atoi(v)
+				out = append(out, i)
+			}
+			return out
+		}
+		src, dst := ix("sources"), ix("destinations")
+		dist := make([][]float64, len(src))
+		dur := make([][]float64, len(src))
+		for i, a := range src {
+			for _, b := range dst {
+				d := haver(crd[a], crd[b]) * 1000
+				dist[i] = append(dist[i], d)
+				dur[i] = append(dur[i], d/1000*60)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": "Ok", "distances": dist, "durations": dur})
+	}))
+	defer osrm.Close()
+
+	cache := filepath.Join(dir, "cache")
+	e, err := newEngine(osrm.URL, "osrm", "", 2, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := e.matrix(ps, "time")
+	if err != nil {
+		t.Fatalf("engine.matrix(osrm) error: %v", err)
+	}
+	if hits != 9 {
+		t.Errorf("engine.matrix(osrm) expected 9 chunked requests received %d", hits)
+	}
+	for i := range ps {
+		for j := range ps {
+			if math.Abs(m.dist[i][j]-haver(ps[i], ps[j])) > 1e-3 || math.Abs(m.cost[i][j]-m.dist[i][j]) > 1e-6 {
+				t.Errorf("engine.matrix(osrm) [%d][%d] expected %f received %f and %f min", i, j, haver(ps[i], ps[j]), m.dist[i][j], m.cost[i][j])
+			}
+		}
+	}
+
+	// another server never shares cached tables
+	other := *e
+	other.url = "http://elsewhere:5000"
+	if other.key(ps, ps) == e.key(ps, ps) {
+		t.Errorf("engine.key expected a different key per server")
+	}
+
+	// second pass is all cache, then the engine going down is an error
+	if _, err := e.matrix(ps, "dist"); err != nil || hits != 9 {
+		t.Errorf("engine.matrix(osrm) expected cached result, received %d requests (%v)", hits, err)
+	}
+	osrm.Close()
+	e.cache = ""
+	if _, err := e.matrix(ps, "dist"); err == nil {
+		t.Errorf("engine.matrix expected error with engine down")
+	}
+
+	// valhalla, no route from the first source falls back to haver
+	val := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Sources, Targets []struct{ Lat, Lon float64 }
+			Costing          string
+		}
+		if r.URL.Path != "/sources_to_targets" || json.NewDecoder(r.Body).Decode(&req) != nil || req.Costing != "auto" {
+			http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		var out [][]map[string]interface{}
+		for i := range req.Sources {
+			var row []map[string]interface{}
+			for range req.Targets {
+				v := map[string]interface{}{"distance": 2.0, "time": 60}
+				if i == 0 {
+					v = map[string]interface{}{"distance": nil, "time": nil}
+				}
+				row = append(row, v)
+			}
+			out = append(out, row)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"sources_to_targets": out})
+	}))
+	defer val.Close()
+
+	e, _ = newEngine(val.URL, "valhalla", "", 10, "")
+	m, err = e.matrix(ps, "dist")
+	if err != nil {
+		t.Fatalf("engine.matrix(valhalla) error: %v", err)
+	}
+	if m.cost[1][2] != 2 || m.dur[1][2] != 1 || math.Abs(m.cost[0][1]-haver(ps[0], ps[1])) > 1e-9 {
+		t.Errorf("engine.matrix(valhalla) expected 2km 1min and haver fallback, received %f %f %f", m.cost[1][2], m.dur[1][2], m.cost[0][1])
+	}
+
+}
+
+// test geoDist models
+func TestGeoDist(t *testing.T) {
+	fp := point{-37.95103341666667, 144.42486788888889, "Flinders Peak"}
+	bn := point{-37.65282113888889, 143.92649552777777, "Buninyong"}
+	defer func() { distMod = "haver" }()
+
+	// ellipsoid against published values
+	var cases = []struct {
+		p1, p2 point
+		km     float64
+	}{
+		{fp, bn, 54.972271},
+		{point{0, 0, ""}, point{0, 90, ""}, 10018.754171},
+		{point{0, 0, ""}, point{0, 180, ""}, 20003.931459}, // over the pole
+		{point{0, 0, ""}, point{0.5, 179.7, ""}, 19944.127421},
+	}
+	for _, m := range []string{"vincenty", "karney"} {
+		distMod = m
+		for _, tst := range cases {
+			if val := geoDist(tst.p1, tst.p2); math.Abs(val-tst.km) > 1e-6 {
+				t.Errorf("%s(%v, %v) expected %f received %f", m, tst.p1, tst.p2, tst.km, val)
+			}
+		}
+	}
+
+	// nearly antipodal, vincenty does not converge and uses karney
+	p1, p2 := point{-30.12345, 0, ""}, point{30.12344, 179.9999, ""}
+	if k, v := karney(p1, p2), vincenty(p1, p2); math.IsNaN(k) || k != v || k < 20000 || k > 20004 {
+		t.Errorf("karney antipodal expected about 20003.93 received %f (vincenty %f)", k, v)
+	}
+
+	// spheres and the fast estimate
+	distMod = "sphere"
+	if val := geoDist(fp, bn); math.Abs(val-haver(fp, bn)*6371.0088/6378.1) > floatErrorMax || val >= haver(fp, bn) {
+		t.Errorf("sphere expected mean radius distance received %f", val)
+	}
+	distMod = "fast"
+	if val, sph := geoDist(fp, bn), haver(fp, bn)*6371.0088/6378.1; math.Abs(val-sph)/sph > 1e-3 {
+		t.Errorf("fast expected near %f received %f", sph, val)
+	}
+	for _, m := range distMods {
+		distMod = m
+		if val := geoDist(fp, fp); val != 0 {
+			t.Errorf("%s same point expected 0 received %f", m, val)
+		}
+	}
+
+}
+
+// test orient
+func TestOrient(t *testing.T) {
+	home := point{45, -122, "home"}
+	n1, s5 := point{45.01, -122, "n1"}, point{44.99, -122, "s5"}
+	ps := pnts{n1, home, s5}
+	for i := 1; i <= 8; i++ {
+		ps = append(ps, point{45, -122 + float64(i)*0.01, "e" + strconv.Itoa(i)})
+	}
+	leg := haver(home, n1)
+
+	// room for one of n1 or s5, the higher score wins
+	score := map[point]float64{n1: 1, s5: 5}
+	tour, skip := ps.orient(1, 2*leg+1e-6, score, 0.8)
+	if len(tour) != 2 || tour[0] != home || tour[1] != s5 || len(skip) != len(ps)-2 {
+		t.Errorf("orient by score expected [home s5] received %v", tour)
+	}
+
+	// equal scores take as many stops as fit and stay in budget
+	for _, bud := range []float64{0, 3, 8, 12, 40} {
+		tour, skip := ps.orient(1, bud, nil, 0.8)
+		if tour[0] != home || len(tour)+len(skip) != len(ps) || tour.tourLen() > bud+1e-9 {
+			t.Errorf("orient(%v) expected tour from home within budget received %v (%f)", bud, tour, tour.tourLen())
+		}
+		if bud == 40 && len(skip) != 0 {
+			t.Errorf("orient(%v) expected every stop received %d skipped", bud, len(skip))
+		}
+	}
+
+	// fillTour keeps the length it is handed in step with the tour
+	ft, _, tl, got := fillTour(pnts{home}, pnts{n1, s5}, 0, 2*leg+1e-6, func(point) float64 { return 1 })
+	if got != 1 || math.Abs(tl-ft.tourLen()) > floatErrorMax {
+		t.Errorf("fillTour expected 1 stop and length %f received %v and %f", ft.tourLen(), got, tl)
+	}
+
+}
+
+// n stops s0.. evenly round a 0.05 degree circle at 45,-122, counterclockwise from the east
+func ring(n int) pnts {
+	var ps pnts
+	for i := 0; i < n; i++ {
+		a := float64(i) * 2 * math.Pi / float64(n)
+		ps = append(ps, point{45 + 0.05*math.Sin(a), -122 + 0.05*math.Cos(a), "s" + strconv.Itoa(i)})
+	}
+	return ps
+}
+
+// test precedence rules
+func TestPrec(t *testing.T) {
+	ps := ring(9)
+	home := ps[0]
+
+	// errors
+	var bad = []struct {
+		pair, after map[point]string
+	}{
+		{map[point]string{ps[1]: "x"}, nil},                                       // no delivery
+		{map[point]string{ps[1]: "x", ps[2]: "x", ps[3]: "x"}, nil},               // three stops
+		{nil, map[point]string{ps[1]: "s2", ps[2]: "s1"}},                         // loop
+		{nil, map[point]string{ps[0]: "s4"}},                                      // start after
+		{nil, map[point]string{ps[1]: "nope"}},                                    // missing label
+		{map[point]string{ps[3]: "x", ps[5]: "x"}, map[point]string{ps[3]: "s5"}}, // pair loop
+	}
+	for i, tst := range bad {
+		if _, err := newPrec(ps, home, tst.pair, tst.after); err == nil {
+			t.Errorf("newPrec case %d expected error", i)
+		}
+	}
+
+	// s1 picked up then dropped at s2, s7 after s4: the ring must run forward
+	g, err := newPrec(ps, home, map[point]string{ps[2]: "p", ps[1]: "p"}, map[point]string{ps[7]: "s4"})
+	if err != nil {
+		t.Fatalf("newPrec error: %v", err)
+	}
+	prec = g
+	defer func() { prec = nil }()
+
+	back := append(pnts{home}, rev(ps[1:])...)
+	if !g.ok(ps) || g.ok(back) {
+		t.Errorf("ok expected true for the forward ring and false backward")
+	}
+	nn := ps.nna(0)
+	for nm, tour := range map[string]pnts{
+		"nna":    back.nna(0),
+		"opt2SA": nn.opt2SA(0.8, false, -1, true),
+		"exh":    back.exh(),
+	} {
+		if len(tour) != len(ps) || !g.ok(tour) {
+			t.Errorf("%s expected feasible tour received %v", nm, tour)
+		}
+	}
+
+	// forward ring is optimal so no rule binds, s2 after s3 forces a detour
+	for i, v := range g.binding(ps) {
+		if v != 0 {
+			t.Errorf("binding(ring) rule %d expected 0 received %f", i, v)
+		}
+	}
+	zig := pnts{home, ps[1], ps[3], ps[2], ps[4], ps[5], ps[6], ps[7], ps[8]}
+	g2, _ := newPrec(ps, home, nil, map[point]string{ps[2]: "s3"})
+	if !g2.ok(zig) {
+		t.Fatalf("zig expected feasible")
+	}
+	if save := g2.binding(zig); save[0] <= 0 {
+		t.Errorf("binding(zig) expected s3 before s2 to bind received %v", save)
+	}
+
+}
+
+// test newLock and the optimizers keeping locks
+func TestLocks(t *testing.T) {
+	ps := ring(9)
+	home := ps[0]
+
+	// errors
+	var bad = []map[point]string{
+		{ps[1]: "3", ps[2]: "3"}, // same position
+		{ps[1]: "10"},            // past the end
+		{ps[1]: "nope"},          // unknown
+		{ps[1]: "seq:a"},         // one stop sequence
+		{ps[0]: "4"},             // start fixed elsewhere
+	}
+	for i, col := range bad {
+		if _, err := newLock(ps, home, col); err == nil {
+			t.Errorf("newLock case %d expected error", i)
+		}
+	}
+	if _, err := newLock(ps, ps[2], map[point]string{ps[1]: "seq:a", ps[2]: "seq:a"}); err == nil {
+		t.Errorf("newLock expected error for a start inside a sequence")
+	}
+
+	// a first stop becomes home
+	if l, err := newLock(ps, home, map[point]string{ps[4]: "First"}); err != nil || l.home != ps[4] {
+		t.Errorf("newLock first expected home %v received %v, %v", ps[4], l, err)
+	}
+
+	// s6 at position 2, s1 last, s3 s5 s7 back to back in file order
+	l, err := newLock(ps, home, map[point]string{ps[6]: "2", ps[1]: "last", ps[7]: "seq:x", ps[3]: "seq:x", ps[5]: "seq:x"})
+	if err != nil {
+		t.Fatalf("newLock error: %v", err)
+	}
+	lock = l
+	defer func() { lock = nil }()
+
+	if l.ok(ps) {
+		t.Errorf("ok expected false for the ring")
+	}
+	good := pnts{ps[2], ps[8], ps[3], ps[5], ps[7], ps[4], ps[1], home, ps[6]}
+	if !l.ok(good) {
+		t.Errorf("ok expected true for %v", good)
+	}
+
+	nn := ps.nna(0)
+	for nm, tour := range map[string]pnts{
+		"nna":    nn,
+		"opt2SA": nn.opt2SA(0.8, false, -1, true),
+		"exh":    ps.exh(),
+	} {
+		if len(tour) != len(ps) || !l.ok(tour) {
+			t.Errorf("%s expected locked tour received %v", nm, tour)
+		}
+	}
+
+}
+
+// test readRoute, insStops and repair
+func TestInsert(t *testing.T) {
+	ps := ring(10)
+
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// published without s3 and s7, read back in order either format
+	pub := pnts{ps[0], ps[1], ps[2], ps[4], ps[5], ps[6], ps[8], ps[9]}
+	for _, f := range []bool{true, false} {
+		path := filepath.Join(dir, "out.txt")
+		ctr, d := pub.centPnt()
+		if err := writeFile(pub, ctr, d, path, f, true, pub.tourLen()); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readRoute(path)
+		if err != nil {
+			t.Fatalf("readRoute(format %v) error: %v", f, err)
+		}
+		if len(got) != len(pub) || got[3].lab != "s4" {
+			t.Errorf("readRoute(format %v) expected %d stops in order received %v", f, len(pub), got)
+		}
+	}
+
+	out, ext := insStops(pub, pnts{ps[7], ps[3]})
+	if !samePnts(out, ps) {
+		t.Errorf("insStops expected the ring received %v", out)
+	}
+	if want := ps.tourLen() - pub.tourLen(); math.Abs(ext[ps[3]]+ext[ps[7]]-want) > 1e-9 {
+		t.Errorf("insStops expected %f added received %v", want, ext)
+	}
+
+	// a crossing next to the insert is repaired, the start stays first
+	cross := pnts{ps[0], ps[1], ps[2], ps[4], ps[3], ps[5], ps[6], ps[7], ps[8], ps[9]}
+	if got := repair(cross, map[point]bool{ps[5]: true}, 3); !samePnts(got, ps) {
+		t.Errorf("repair expected the ring received %v", got)
+	}
+	if got := repair(cross, map[point]bool{ps[8]: true}, 2); !samePnts(got, cross) {
+		t.Errorf("repair expected no change out of the window received %v", got)
+	}
+
+	// rows follow the repaired tour, not where the stop first went in
+	fix := repair(cross, map[point]bool{ps[3]: true}, 3)
+	rows := insRows(fix, pnts{ps[3]})
+	want := fmt.Sprintf("%.4f", cost(ps[2], ps[3])+cost(ps[3], ps[4])-cost(ps[2], ps[4]))
+	if r := rows[1]; !samePnts(fix, ps) || r[1] != "4" || r[2] != "s2" || r[3] != "s4" || r[4] != want {
+		t.Errorf("insRows expected s3 4th between s2 and s4 adding %s received %v", want, r)
+	}
+
+}
+
+// test newReplan, route and moved
+func TestReplan(t *testing.T) {
+	ps := ring(10)
+
+	// previous plan zigzags at s4 s5, had a stop x, lacked s9
+	x := point{46, -121, "x"}
+	prev := pnts{ps[0], ps[1], ps[2], ps[3], ps[5], ps[4], x, ps[6], ps[7], ps[8]}
+	r := newReplan(prev, ps)
+	if len(r.old) != 9 || len(r.gone) != 1 || len(r.added) != 1 || r.added[0] != ps[9] {
+		t.Fatalf("newReplan expected 9 kept, x removed and s9 added received %v %v %v", r.old, r.gone, r.added)
+	}
+
+	free := r.route(0)
+	if !samePnts(free, ps) {
+		t.Errorf("route(0) expected the ring received %v", free)
+	}
+	if mv := r.moved(free); len(mv) != 1 || !(mv[ps[4]] || mv[ps[5]]) {
+		t.Errorf("moved expected s4 or s5 received %v", mv)
+	}
+
+	// a high weight keeps the previous order
+	seed, _ := insStops(r.old, r.added)
+	kept := r.route(100)
+	if !samePnts(kept, seed) || len(r.moved(kept)) != 0 {
+		t.Errorf("route(100) expected the previous order received %v", kept)
+	}
+	if r.score(kept, 100) > r.score(free, 100) {
+		t.Errorf("score expected the previous order to be cheaper at weight 100")
+	}
+
+	// directed costs, s0 s1 s2.. is cheap and the previous order ran against it
+	m := newMatrix(ps)
+	for i := range ps {
+		for j := range ps {
+			if i != j {
+				m.dist[i][j] = 10
+			}
+		}
+		m.dist[i][(i+1)%len(ps)] = 1
+	}
+	mat = m
+	defer func() { mat = nil }()
+	back := append(pnts{ps[0]}, rev(ps[1:])...)
+	rb := newReplan(back, ps)
+	if got := rb.route(0); got.tourLen() >= back.tourLen() || got.tourLen() != rb.score(got, 0) {
+		t.Errorf("route(0) on directed costs expected shorter than %f received %f (%v)", back.tourLen(), got.tourLen(), got)
+	}
+
+}
+
+// test eval and crosses
+func TestEval(t *testing.T) {
+	// bow tie, the diagonals cross
+	ps := pnts{{45, -122, "a"}, {45.1, -121.9, "b"}, {45, -121.9, "c"}, {45.1, -122, "d"}}
+	e := ps.eval()
+
+	if e.nCrs != 1 || e.cross[1] != 1 || e.cross[3] != 1 || e.cross[0] != 0 {
+		t.Errorf("eval expected legs 1 and 3 to cross received %v", e.cross)
+	}
+	if e.long[0] != 1 && e.long[0] != 3 {
+		t.Errorf("eval expected a diagonal longest received leg %d", e.long[0])
+	}
+	if want := ps.tourLen() - e.leg[0]; math.Abs(e.cum[3]-want) > floatErrorMax {
+		t.Errorf("eval cum expected %f received %f", want, e.cum[3])
+	}
+	if math.Abs(e.leg[0]-geoDist(ps[3], ps[0])) > floatErrorMax {
+		t.Errorf("eval leg 0 expected the return leg received %f", e.leg[0])
+	}
+
+	sq := pnts{ps[0], ps[2], ps[1], ps[3]}
+	if e := sq.eval(); e.nCrs != 0 {
+		t.Errorf("eval expected no crossings on the square received %d", e.nCrs)
+	}
+	if crosses(ps[0], ps[2], ps[2], ps[1]) {
+		t.Errorf("crosses expected false for legs sharing a stop")
+	}
+
+}
+
+// test bearing, compass and the extra route columns
+func TestLegCols(t *testing.T) {
+	o := point{45, -122, "o"}
+	var cases = []struct {
+		to   point
+		want float64
+		head string
+	}{
+		{point{46, -122, "n"}, 0, "N"},
+		{point{45, -121, "e"}, 89.6, "E"},
+		{point{44, -122, "s"}, 180, "S"},
+		{point{44, -123, "sw"}, 215.8, "SW"},
+	}
+	for _, c := range cases {
+		b := bearing(o, c.to)
+		if math.Abs(b-c.want) > 0.1 || compass(b) != c.head {
+			t.Errorf("bearing(%s) expected %.1f %s received %.1f %s", c.to.lab, c.want, c.head, b, compass(b))
+		}
+	}
+	if compass(355) != "N" {
+		t.Errorf("compass(355) expected N received %s", compass(355))
+	}
+
+	outCols = []string{"leg", "cum", "time", "bear"}
+	defer func() { outCols = nil }()
+	ps := pnts{o, cases[0].to, cases[1].to}
+	rows := make([][]string, len(ps))
+	hdr := addLegCols(ps, rows, nil)
+	if strings.Join(hdr, ",") != "leg,cum,time,bear,head" {
+		t.Errorf("addLegCols header received %v", hdr)
+	}
+	cum := cost(ps[0], ps[1]) + cost(ps[1], ps[2])
+	if rows[0][0] != "0.0000" || rows[2][1] != fmt.Sprintf("%.4f", cum) || rows[2][4] != "W" {
+		t.Errorf("addLegCols rows received %v", rows)
+	}
+	if want := fmt.Sprintf("%.1f", cost(ps[0], ps[1])/40*60); rows[1][2] != want {
+		t.Errorf("addLegCols time expected %s received %s", want, rows[1][2])
+	}
+
+}
+
+// test dayLim and split
+func TestSplit(t *testing.T) {
+	var ps pnts
+	for i := 0; i < 7; i++ {
+		ps = append(ps, point{45 + 0.01*float64(i), -122, "s" + strconv.Itoa(i)})
+	}
+	leg := cost(ps[0], ps[1]) // ~1.11km
+	base := point{45.03, -122.005, "base"}
+
+	var cases = []struct {
+		lim  dayLim
+		base *point
+		want []int // stops per day
+	}{
+		{dayLim{stops: 3, kph: 40}, nil, []int{3, 3, 1}},
+		{dayLim{dist: 2.5 * leg, kph: 40}, nil, []int{3, 3, 1}},
+		{dayLim{dist: 8 * leg, kph: 40}, &base, []int{4, 3}},
+		{dayLim{hrs: 1, svc: 20, kph: 40}, nil, []int{2, 2, 2, 1}},
+		{dayLim{stops: 4, hrs: 10, svc: 20, kph: 40}, nil, []int{4, 3}},
+	}
+	for i, c := range cases {
+		days, over := ps.split(c.lim, c.base)
+		var got []int
+		n := 0
+		for _, d := range days {
+			got = append(got, len(d))
+			for _, p := range d {
+				if p != ps[n] {
+					t.Fatalf("split case %d expected stops in tour order", i)
+				}
+				n++
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) || len(over) != 0 {
+			t.Errorf("split case %d expected %v received %v over %v", i, c.want, got, over)
+		}
+	}
+
+	// a stop too far for the base gets its own day
+	far := append(append(pnts{}, ps[:2]...), point{46, -122, "far"})
+	days, over := far.split(dayLim{dist: 20, kph: 40}, &base)
+	if len(days) != 2 || len(over) != 1 || over[0].lab != "far" {
+		t.Errorf("split expected far over the limit received %v over %v", days, over)
+	}
+
+	// with a matrix file the base legs come from it as well
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mf := "\ts0\ts1\tbase\ns0\t0\t5\t7\ns1\t5\t0\t9\nbase\t7\t9\t0\n"
+	ioutil.WriteFile(filepath.Join(dir, "m.tsv"), []byte(mf), 0644)
+	defer func(m, b, mt string, n int) { *matFile, *dayBase, *metric, *dayStops, mat = m, b, mt, n, nil }(*matFile, *dayBase, *metric, *dayStops)
+	*matFile, *dayBase, *metric, *dayStops = "m.tsv", "45.03,-122.005", "time", 1
+	if err := setMatrix(ps[:2], dir); err != nil {
+		t.Fatalf("setMatrix with -base error: %v", err)
+	}
+	if ln, _ := (dayLim{}).measure(ps[:2], &base); ln != 21 {
+		t.Errorf("measure expected base legs from the matrix, 7+5+9, received %f", ln)
+	}
+	if err := setMatrix(ps[:3], dir); err == nil {
+		t.Errorf("setMatrix expected an error for a matrix missing stops")
+	}
+
+}
+
+// test maxLeg, crewSplit and the optimizers on other objectives
+func TestObjective(t *testing.T) {
+	home := point{45, -122, "home"}
+
+	// three stops in each of four directions, 5-7km out
+	var ps pnts
+	for d, off := range [][2]float64{{1, 0}, {0, 1}, {-1, 0}, {0, -1}} {
+		for i := 0; i < 3; i++ {
+			r := 0.05 + 0.01*float64(i)
+			ps = append(ps, point{45 + off[0]*r, -122 + off[1]*r*1.4, fmt.Sprintf("d%d_%d", d, i)})
+		}
+	}
+	tour := append(pnts{home}, ps...)
+
+	l, i := tour.maxLeg()
+	for j := range tour {
+		if d := cost(tour[j], tour[(j+1)%len(tour)]); d > l || j != i && d == l {
+			t.Fatalf("maxLeg expected the longest leg received %f at %d", l, i)
+		}
+	}
+
+	rts, max := tour.crewSplit(home, 4)
+	if len(rts) != 4 {
+		t.Fatalf("crewSplit(4) expected a route per direction received %d", len(rts))
+	}
+	for _, r := range rts {
+		if r[0] != home || len(r) != 4 || r.tourLen() > max+1e-9 {
+			t.Errorf("crewSplit(4) expected home and 3 stops within %f received %v", max, r)
+		}
+	}
+	if _, one := tour.crewSplit(home, 1); math.Abs(one-tour.tourLen()) > 1e-9 {
+		t.Errorf("crewSplit(1) expected the tour length received %f", one)
+	}
+	if _, two := tour.crewSplit(home, 2); two >= tour.tourLen() || two < max {
+		t.Errorf("crewSplit(2) expected between %f and %f received %f", max, tour.tourLen(), two)
+	}
+
+	// optimizers lower the active objective from a shuffled start
+	defer func() { objKind, objCrews = "sum", 1 }()
+	mix := append(pnts{home}, ps.shuffle()...)
+	for _, k := range []string{"bottle", "minmax"} {
+		objKind, objCrews, objHome = k, 3, home
+		nn := mix.nna(0)
+		out := nn.opt2SA(0.8, false, -1, false)
+		if out.objVal() > nn.objVal()+1e-9 {
+			t.Errorf("opt2SA(%s) expected %f or less received %f", k, nn.objVal(), out.objVal())
+		}
+	}
+
+}
+
+// test cluster route maps draw the closed tour
+func TestClsRouteDraw(t *testing.T) {
+	ps := ring(5)
+	c := []cluster{{ps[0], ps}, {ps[0], nil}}
+	d := clsRouteDraw(c)
+	if len(d.lines) != 1 {
+		t.Fatalf("clsRouteDraw expected 1 line received %d", len(d.lines))
+	}
+	l := d.lines[0].ps
+	if len(l) != len(ps)+1 || l[len(l)-1] != ps[0] {
+		t.Errorf("clsRouteDraw expected the line back to %v received %v", ps[0], l)
+	}
+	var ln float64
+	for i := 1; i < len(l); i++ {
+		ln += cost(l[i-1], l[i])
+	}
+	if math.Abs(ln-ps.tourLen()) > 1e-9 {
+		t.Errorf("clsRouteDraw expected a line of %f received %f", ps.tourLen(), ln)
+	}
+
+}
+
+// test parseClr, extent and arrowHead
+func TestMapStyle(t *testing.T) {
+	var clrs = []struct {
+		in   string
+		want color.RGBA
+		err  bool
+	}{
+		{"ff3333", color.RGBA{255, 51, 51, 255}, false},
+		{"#00Ff10", color.RGBA{0, 255, 16, 255}, false},
+		{"fff", color.RGBA{}, true},
+		{"zz0000", color.RGBA{}, true},
+	}
+	for _, c := range clrs {
+		got, err := parseClr(c.in)
+		if got != c.want || (err != nil) != c.err {
+			t.Errorf("parseClr(%q) expected %v (err %v) received %v, %v", c.in, c.want, c.err, got, err)
+		}
+	}
+
+	// at 60N a degree of lon is half a degree of lat on the ground
+	ps := pnts{{60, 10, "a"}, {60.5, 10, "b"}, {60.5, 12, "c"}}
+	if e := ps.extent(); math.Abs(e-1) > 0.01 {
+		t.Errorf("extent expected ~1 received %f", e)
+	}
+
+	for _, leg := range [][2]point{{ps[0], ps[1]}, {ps[1], ps[2]}, {ps[2], ps[0]}} {
+		a, b := leg[0], leg[1]
+		h := arrowHead(a, b, 0.05)
+		tip := h[1]
+		if math.Abs(tip.lat-(a.lat+b.lat)/2) > 1e-12 || math.Abs(tip.lon-(a.lon+b.lon)/2) > 1e-12 {
+			t.Errorf("arrowHead(%s,%s) expected the tip mid leg received %v", a.lab, b.lab, tip)
+		}
+		for _, w := range []point{h[0], h[2]} {
+			// barbs trail the tip against the direction of travel, l long on the ground
+			if (w.lat-tip.lat)*(b.lat-a.lat)+(w.lon-tip.lon)*(b.lon-a.lon) >= 0 {
+				t.Errorf("arrowHead(%s,%s) expected barbs behind the tip received %v", a.lab, b.lab, w)
+			}
+			if d := dirLen(tip, w); math.Abs(d-0.05) > 1e-3 {
+				t.Errorf("arrowHead(%s,%s) expected barb 0.05 received %f", a.lab, b.lab, d)
+			}
+		}
+	}
+
+}
+
+// solid color tiles everywhere
+type fillTiles color.RGBA
+
+func (f fillTiles) tile(z, x, y int) (image.Image, error) {
+	img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = f.R, f.G, f.B, f.A
+	}
+	return img, nil
+}
+
+func (f fillTiles) maxZoom() int { return -1 }
+
+func (f fillTiles) close() {}
+
+// test the mercator view, local tiles, plain render and prefetch
+func TestTiles(t *testing.T) {
+	if x, y := mercPx(point{0, 0, ""}, 0); x != 128 || math.Abs(y-128) > 1e-9 {
+		t.Errorf("mercPx(0,0) expected 128,128 received %f,%f", x, y)
+	}
+	if x, y := tileXY(point{51.5074, -0.1278, "london"}, 10); x != 511 || y != 340 {
+		t.Errorf("tileXY(london, 10) expected 511,340 received %d,%d", x, y)
+	}
+
+	ps := pnts{{45.5, -122.7, "a"}, {45.6, -122.5, "b"}, {45.45, -122.6, "c"}}
+	v := fitView(ps, 400, 300, 20)
+	for _, p := range ps {
+		if x, y := v.px(p); x < 20 || x > 380 || y < 20 || y > 280 {
+			t.Errorf("fitView z%d put %s off the canvas at %f,%f", v.z, p.lab, x, y)
+		}
+	}
+	if w := fitView(ps, 800, 600, 20); w.z != v.z+1 {
+		t.Errorf("fitView expected z%d on twice the canvas received z%d", v.z+1, w.z)
+	}
+
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// one tile on disk
+	td := filepath.Join(dir, "tiles")
+	os.MkdirAll(filepath.Join(td, "3", "1"), 0755)
+	f, err := os.Create(filepath.Join(td, "3", "1", "2.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := fillTiles(color.RGBA{0, 0, 255, 255}).tile(0, 0, 0)
+	png.Encode(f, img)
+	f.Close()
+
+	src, err := openTiles(td)
+	if err != nil {
+		t.Fatalf("openTiles error: %v", err)
+	}
+	if _, err := src.tile(3, 1, 2); err != nil {
+		t.Errorf("tileDir expected 3/1/2 received %v", err)
+	}
+	if _, err := src.tile(3, 1, 3); err == nil {
+		t.Error("tileDir expected an error on a missing tile")
+	}
+	if z := src.maxZoom(); z != 3 {
+		t.Errorf("tileDir expected max zoom 3 received %d", z)
+	}
+	if _, err := openTiles(filepath.Join(dir, "none.mbtiles")); err == nil {
+		t.Error("openTiles expected an error on a missing file")
+	}
+
+	// a lone marker in the middle over blue tiles
+	defer func(s mapStyle) { style = s }(style)
+	style.w, style.h = 200, 100
+	var d mapDraw
+	d.mark(ps[0], color.RGBA{255, 0, 0, 255}, 20, "")
+	out := d.plain(fillTiles(color.RGBA{0, 0, 255, 255}))
+	if r, g, b, _ := out.At(100, 50).RGBA(); r>>8 != 255 || g != 0 || b != 0 {
+		t.Errorf("plain expected a red marker mid canvas received %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+	if r, _, b, _ := out.At(2, 2).RGBA(); r != 0 || b>>8 != 255 {
+		t.Errorf("plain expected blue tiles in the corner received %d,%d", r>>8, b>>8)
+	}
+
+	// a lone stop zooms far past a dir holding only zoom 3, its tile is scaled up to fill the canvas
+	x3, y3 := tileXY(ps[0], 3)
+	os.MkdirAll(filepath.Join(td, "3", strconv.Itoa(x3)), 0755)
+	if f, err = os.Create(filepath.Join(td, "3", strconv.Itoa(x3), strconv.Itoa(y3)+".png")); err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, img)
+	f.Close()
+	out = d.plain(src)
+	if r, _, b, _ := out.At(2, 97).RGBA(); r != 0 || b>>8 != 255 {
+		t.Errorf("plain expected the zoom 3 tile scaled under the view received %d,%d", r>>8, b>>8)
+	}
+
+	// prefetch a small box then again with everything already there
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if strings.HasPrefix(r.URL.Path, "/9/") {
+			http.NotFound(w, r)
+			return
+		}
+		png.Encode(w, img)
+	}))
+	defer srv.Close()
+
+	pd := filepath.Join(dir, "pre")
+	lo, hi := point{45.45, -122.7, ""}, point{45.6, -122.5, ""}
+	got, skip, err := prefetch(pd, srv.URL+"/{z}/{x}/{y}.png", lo, hi, 5, 8)
+	if err != nil || got == 0 || skip != 0 || got != n {
+		t.Errorf("prefetch expected every tile fetched received %d (%d requests), %d skipped, %v", got, n, skip, err)
+	}
+	x, y := tileXY(lo, 8)
+	if _, err := os.Stat(filepath.Join(pd, "8", strconv.Itoa(x), strconv.Itoa(y)+".png")); err != nil {
+		t.Errorf("prefetch expected tile 8/%d/%d on disk: %v", x, y, err)
+	}
+	again, skip, err := prefetch(pd, srv.URL+"/{z}/{x}/{y}.png", lo, hi, 5, 8)
+	if err != nil || again != 0 || skip != got {
+		t.Errorf("prefetch expected %d skipped received %d fetched, %d skipped, %v", got, again, skip, err)
+	}
+	if _, _, err := prefetch(pd, srv.URL+"/{z}/{x}/{y}.png", lo, hi, 9, 9); err == nil {
+		t.Error("prefetch expected an error on a missing tile")
+	}
+	if _, _, err := prefetch(pd, srv.URL+"/{z}/{x}/{y}.png", point{-80, -180, ""}, point{80, 180, ""}, 0, 10); err == nil {
+		t.Error("prefetch expected an error on too many tiles")
+	}
+
+}
+
+// test svg map output
+func TestSVG(t *testing.T) {
+	defer func(s mapStyle) { style = s }(style)
+	style.w, style.h = 400, 300
+
+	ps := pnts{{45.5, -122.7, "a & b"}, {45.6, -122.5, "c"}, {45.45, -122.6, "d"}}
+	var d mapDraw
+	d.line(ps, style.path, 3)
+	for i, p := range ps {
+		d.mark(p, style.mkr, 14, strconv.Itoa(i+1))
+	}
+	var buf bytes.Buffer
+	if err := d.svg(&buf); err != nil {
+		t.Fatalf("svg error: %v", err)
+	}
+
+	var doc struct {
+		W     int `xml:"width,attr"`
+		Lines []struct {
+			Pts string `xml:"points,attr"`
+		} `xml:"g>polyline"`
+		Stops []struct {
+			X     float64 `xml:"cx,attr"`
+			Y     float64 `xml:"cy,attr"`
+			Fill  string  `xml:"fill,attr"`
+			Title string  `xml:"title"`
+		} `xml:"g>circle"`
+		Labs []string `xml:"g>text"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("svg is not valid xml: %v\n%s", err, buf.String())
+	}
+	if doc.W != 400 || len(doc.Lines) != 1 || len(doc.Stops) != 3 || len(doc.Labs) != 3 {
+		t.Fatalf("svg expected 400 wide with 1 line, 3 stops and 3 labels received %d, %d, %d, %d", doc.W, len(doc.Lines), len(doc.Stops), len(doc.Labs))
+	}
+	if n := len(strings.Fields(doc.Lines[0].Pts)); n != 3 {
+		t.Errorf("svg expected 3 path points received %d", n)
+	}
+
+	// stops land where the png puts them
+	v := d.view()
+	for i, s := range doc.Stops {
+		x, y := v.px(ps[i])
+		if math.Abs(s.X-x) > 0.05 || math.Abs(s.Y-y) > 0.05 || s.X < 0 || s.X > 400 || s.Y < 0 || s.Y > 300 {
+			t.Errorf("svg stop %d expected at %f,%f received %f,%f", i, x, y, s.X, s.Y)
+		}
+		if s.Title != ps[i].lab || s.Fill != "#ff3333" || doc.Labs[i] != strconv.Itoa(i+1) {
+			t.Errorf("svg stop %d received %+v labeled %q", i, s, doc.Labs[i])
+		}
+	}
+
+}
+
+// test html map output
+func TestHTML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "leaflet.js"), []byte("var L = {}; // </script>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "leaflet.css"), []byte(".leaflet-pane {}"), 0644)
+
+	defer func(d string, nb bool) { *leafDir, *noBase, leafJS, leafCSS = d, nb, "", "" }(*leafDir, *noBase)
+	*leafDir, *noBase, leafJS = dir, true, ""
+
+	ps := pnts{{45.5, -122.7, "a <b>"}, {45.6, -122.5, "c"}, {45.45, -122.6, "d"}}
+	d := mapDraw{layer: "cluster 0"}
+	d.line(ps[:2], style.path, 3)
+	d.mark(ps[0], style.mkr, 10, "1")
+	d.popup(ps[0].lab, "stop 1")
+	d.layer = "cluster 1"
+	d.mark(ps[2], style.mkr, 10, "")
+
+	ls := d.htmlLayers()
+	if len(ls) != 2 || ls[0].Name != "cluster 0" || len(ls[0].Lines) != 1 || len(ls[0].Mks) != 1 || len(ls[1].Mks) != 1 || ls[1].Lines == nil {
+		t.Fatalf("htmlLayers expected 2 cluster layers received %+v", ls)
+	}
+	if p := ls[0].Mks[0].Pop; p != "a &lt;b&gt;<br>stop 1" {
+		t.Errorf("htmlLayers expected an escaped popup received %q", p)
+	}
+
+	var buf bytes.Buffer
+	if err := d.html(&buf, filepath.Join(dir, "out_route")); err != nil {
+		t.Fatalf("html error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"<title>out_route</title>", ".leaflet-pane {}", "var L = {}; // <\\/script>", `"name":"cluster 1"`, `tiles = "";`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("html expected %q in the page", want)
+		}
+	}
+	if strings.Contains(out, "src=") || strings.Contains(out, "href=") {
+		t.Error("html expected every asset inline")
+	}
+
+	*leafDir, leafJS = filepath.Join(dir, "none"), ""
+	if err := d.html(&buf, "x"); err == nil {
+		t.Error("html expected an error without leaflet assets")
+	}
+
+	// the built in map script when no dir is given, inlined with nothing fetched
+	if strings.TrimSpace(embJS) == "" || strings.TrimSpace(embCSS) == "" {
+		t.Fatal("html expected the built in map script and style embedded")
+	}
+	*leafDir, leafJS = "", ""
+	buf.Reset()
+	if err := d.html(&buf, filepath.Join(dir, "out_route")); err != nil {
+		t.Fatalf("html error: %v", err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, leafJS) || !strings.Contains(out, leafCSS) || !strings.Contains(out, "circleMarker: function") {
+		t.Error("html expected the built in map script inlined")
+	}
+	if strings.Contains(out, "unpkg") || strings.Contains(out, "<script src=") {
+		t.Error("html expected no script fetched from the network")
+	}
+
+}
+
+// test readFleet and fleetRoute
+func TestFleet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fleet.txt")
+	fl := "depot\tlat\tlon\tvehicle\tcap\tmaxkm\tperkm\n" +
+		"west\t45\t-122.5\tw1\t3\t10\t1\n" +
+		"east\t45\t-122\te1\t3\t10\t1\n" +
+		"east\t45\t-122\te2\t\t100\t2\n"
+	if err := ioutil.WriteFile(path, []byte(fl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := readFleet(path)
+	if err != nil {
+		t.Fatalf("readFleet error: %v", err)
+	}
+	if len(vs) != 3 || vs[0].cap != 3 || vs[2].maxLn != 100 || vs[2].perKm != 2 || vs[1].perKm != 1 {
+		t.Fatalf("readFleet received %+v", vs)
+	}
+
+	// four stops by each depot and one far away
+	var ps pnts
+	for i := 0; i < 4; i++ {
+		d := float64(i+1) * 0.002
+		ps = append(ps, point{45 + d, -122.5 + d, "w" + strconv.Itoa(i)}, point{45 - d, -122 - d, "e" + strconv.Itoa(i)})
+	}
+	far := point{46, -121, "far"}
+	ps = append(ps, far)
+
+	rts, left := ps.fleetRoute(vs, nil, 0.8)
+	if len(left) != 1 || left[0] != far {
+		t.Errorf("fleetRoute expected far unassigned received %v", left)
+	}
+	cnt := 0
+	for _, r := range rts {
+		cnt += len(r.stops)
+		if r.v.cap > 0 && r.load > r.v.cap {
+			t.Errorf("fleetRoute %s over capacity: %f", r.v.lab, r.load)
+		}
+		if tl := r.tour(); r.v.maxLn > 0 && tl.tourLen() > r.v.maxLn {
+			t.Errorf("fleetRoute %s over max length: %f", r.v.lab, tl.tourLen())
+		}
+		for _, p := range r.stops {
+			if r.v.lab == "w1" && p.lab[0] != 'w' {
+				t.Errorf("fleetRoute w1 took %s from the other depot", p.lab)
+			}
+		}
+	}
+	// one west stop overflows w1 and rides the long range e2
+	if cnt != len(ps)-1 || len(rts[0].stops) != 3 || len(rts[2].stops) == 0 {
+		t.Errorf("fleetRoute expected 3 stops on w1 and the overflow on e2 received %d/%d/%d", len(rts[0].stops), len(rts[1].stops), len(rts[2].stops))
+	}
+
+	// vehicle labels that file to the same name, or over unassigned.txt
+	nms := txtNames([]string{fileName("van 1"), fileName("van/1"), fileName("unassigned")})
+	if nms[0] != "van_1.txt" || nms[1] != "van_1_1.txt" || nms[2] != "unassigned_2.txt" {
+		t.Errorf("txtNames expected van_1.txt, van_1_1.txt, unassigned_2.txt received %v", nms)
+	}
+
+}
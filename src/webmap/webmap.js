@@ -0,0 +1,426 @@
+// tss web map, the part of the Leaflet API (https://leafletjs.com) the html maps use:
+// map, tileLayer, layerGroup, polyline, circleMarker with popups and tooltips, layers and scale controls.
+// everything is drawn on one canvas in web mercator so a page needs no downloads, -leaflet swaps in Leaflet itself
+var L = (function () {
+	'use strict';
+	var TS = 256, R = 6378137;
+
+	// lat, lon to world pixels at zoom z
+	function proj(ll, z) {
+		var s = TS * Math.pow(2, z), lat = Math.max(Math.min(ll[0], 85.0511), -85.0511) * Math.PI / 180;
+		return [(ll[1] + 180) / 360 * s, (1 - Math.log(Math.tan(lat) + 1 / Math.cos(lat)) / Math.PI) / 2 * s];
+	}
+
+	function unproj(p, z) {
+		var s = TS * Math.pow(2, z), n = Math.PI * (1 - 2 * p[1] / s);
+		return [Math.atan((Math.exp(n) - Math.exp(-n)) / 2) * 180 / Math.PI, p[0] / s * 360 - 180];
+	}
+
+	function div(cls, parent) {
+		var d = document.createElement('div');
+		d.className = cls;
+		parent.appendChild(d);
+		return d;
+	}
+
+	// tooltips are html like Leaflet's, the canvas wants text
+	function plain(h) {
+		var d = document.createElement('div');
+		d.innerHTML = h;
+		return d.textContent;
+	}
+
+	function Map(id) {
+		var m = this, el = document.getElementById(id);
+		m.el = el;
+		m.groups = [];
+		m.ctr = [0, 0];
+		m.z = 2;
+		m.maxZ = 19;
+		m.tiles = '';
+		m.imgs = {};
+		m.pts = {};
+		el.className += ' tss-map';
+		m.cv = document.createElement('canvas');
+		el.appendChild(m.cv);
+		m.pop = div('tss-pop', el);
+		m.pop.style.display = 'none';
+		m.ctl = div('tss-ctl', el);
+		m.ctl.style.display = 'none';
+		m.scale = null;
+		m.attr = div('tss-attr', el);
+
+		var zm = div('tss-zoom', el);
+		[['+', 1], ['−', -1]].forEach(function (b) {
+			var e = document.createElement('button');
+			e.textContent = b[0];
+			e.onclick = function () { m.setView(m.ctr, m.z + b[1]); };
+			zm.appendChild(e);
+		});
+
+		// drag to pan, pinch or wheel to zoom, click a stop for its popup
+		var c = m.cv, drag = null, acc = 0;
+		c.addEventListener('pointerdown', function (e) {
+			c.setPointerCapture(e.pointerId);
+			m.pts[e.pointerId] = [e.clientX, e.clientY];
+			var ids = Object.keys(m.pts);
+			drag = {x: e.clientX, y: e.clientY, c: proj(m.ctr, m.z), moved: ids.length > 1, span: ids.length > 1 ? m.span() : 0};
+		});
+		c.addEventListener('pointermove', function (e) {
+			if (!drag || !m.pts[e.pointerId]) {
+				return;
+			}
+			m.pts[e.pointerId] = [e.clientX, e.clientY];
+			if (drag.span) {
+				var r = m.span() / drag.span;
+				if (r > 1.5 || r < 0.67) {
+					m.setView(m.ctr, m.z + (r > 1 ? 1 : -1));
+					drag.span = m.span();
+				}
+				return;
+			}
+			var dx = e.clientX - drag.x, dy = e.clientY - drag.y;
+			if (Math.abs(dx) + Math.abs(dy) > 3) {
+				drag.moved = true;
+			}
+			m.ctr = unproj([drag.c[0] - dx, drag.c[1] - dy], m.z);
+			m.draw();
+		});
+		var up = function (e) {
+			delete m.pts[e.pointerId];
+			if (drag && !drag.moved && e.type === 'pointerup') {
+				var b = c.getBoundingClientRect();
+				m.click(e.clientX - b.left, e.clientY - b.top);
+			}
+			drag = null;
+		};
+		c.addEventListener('pointerup', up);
+		c.addEventListener('pointercancel', up);
+		c.addEventListener('wheel', function (e) {
+			e.preventDefault();
+			acc += e.deltaY * (e.deltaMode === 1 ? 40 : 1);
+			if (Math.abs(acc) < 100) {
+				return;
+			}
+			var b = c.getBoundingClientRect();
+			m.zoomAt(m.z + (acc < 0 ? 1 : -1), e.clientX - b.left, e.clientY - b.top);
+			acc = 0;
+		});
+		c.addEventListener('dblclick', function (e) {
+			var b = c.getBoundingClientRect();
+			m.zoomAt(m.z + 1, e.clientX - b.left, e.clientY - b.top);
+		});
+		window.addEventListener('resize', function () { m.draw(); });
+	}
+
+	// distance between the first two touches
+	Map.prototype.span = function () {
+		var p = [], k;
+		for (k in this.pts) {
+			p.push(this.pts[k]);
+		}
+		return p.length < 2 ? 0 : Math.max(Math.hypot(p[0][0] - p[1][0], p[0][1] - p[1][1]), 1);
+	};
+
+	Map.prototype.setView = function (ll, z) {
+		this.ctr = ll;
+		this.z = Math.max(0, Math.min(Math.round(z), this.maxZ));
+		this.draw();
+		return this;
+	};
+
+	// zoom keeping the spot under x, y in place
+	Map.prototype.zoomAt = function (z, x, y) {
+		var w = this.el.clientWidth, h = this.el.clientHeight, c = proj(this.ctr, this.z);
+		var ll = unproj([c[0] - w / 2 + x, c[1] - h / 2 + y], this.z);
+		z = Math.max(0, Math.min(z, this.maxZ));
+		var p = proj(ll, z);
+		return this.setView(unproj([p[0] - x + w / 2, p[1] - y + h / 2], z), z);
+	};
+
+	// deepest zoom that shows every point inside the padding
+	Map.prototype.fitBounds = function (lls, o) {
+		o = o || {};
+		var pad = o.padding || [0, 0], mz = Math.min(o.maxZoom === undefined ? this.maxZ : o.maxZoom, this.maxZ);
+		var w = this.el.clientWidth - 2 * pad[0], h = this.el.clientHeight - 2 * pad[1];
+		var lo = [Infinity, Infinity], hi = [-Infinity, -Infinity];
+		lls.forEach(function (ll) {
+			var p = proj(ll, 0);
+			lo = [Math.min(lo[0], p[0]), Math.min(lo[1], p[1])];
+			hi = [Math.max(hi[0], p[0]), Math.max(hi[1], p[1])];
+		});
+		var z = mz;
+		while (z > 0 && ((hi[0] - lo[0]) * Math.pow(2, z) > w || (hi[1] - lo[1]) * Math.pow(2, z) > h)) {
+			z--;
+		}
+		return this.setView(unproj([(lo[0] + hi[0]) / 2, (lo[1] + hi[1]) / 2], 0), z);
+	};
+
+	Map.prototype.draw = function () {
+		var m = this;
+		if (!m.queued) {
+			m.queued = true;
+			window.requestAnimationFrame(function () {
+				m.queued = false;
+				m.render();
+			});
+		}
+	};
+
+	// screen position of ll
+	Map.prototype.pix = function (ll) {
+		var p = proj(ll, this.z);
+		return [p[0] - this.o[0], p[1] - this.o[1]];
+	};
+
+	Map.prototype.render = function () {
+		var m = this, w = m.el.clientWidth, h = m.el.clientHeight, r = window.devicePixelRatio || 1, c = m.cv;
+		if (c.width !== Math.round(w * r) || c.height !== Math.round(h * r)) {
+			c.width = Math.round(w * r);
+			c.height = Math.round(h * r);
+			c.style.width = w + 'px';
+			c.style.height = h + 'px';
+		}
+		var g = c.getContext('2d'), ctr = proj(m.ctr, m.z);
+		m.o = [Math.round(ctr[0] - w / 2), Math.round(ctr[1] - h / 2)];
+		g.setTransform(r, 0, 0, r, 0, 0);
+		g.fillStyle = '#ddd';
+		g.fillRect(0, 0, w, h);
+
+		if (m.tiles) {
+			var n = Math.pow(2, m.z);
+			for (var ty = Math.max(Math.floor(m.o[1] / TS), 0); ty <= Math.min(Math.floor((m.o[1] + h) / TS), n - 1); ty++) {
+				for (var tx = Math.floor(m.o[0] / TS); tx <= Math.floor((m.o[0] + w) / TS); tx++) {
+					var img = m.tile(m.z, ((tx % n) + n) % n, ty);
+					if (img.complete && img.naturalWidth) {
+						g.drawImage(img, tx * TS - m.o[0], ty * TS - m.o[1], TS, TS);
+					}
+				}
+			}
+		}
+
+		var on = m.groups.filter(function (gr) { return gr.on; });
+		g.lineJoin = g.lineCap = 'round';
+		on.forEach(function (gr) {
+			gr.lines.forEach(function (ln) {
+				g.beginPath();
+				ln.pts.forEach(function (ll, i) {
+					var p = m.pix(ll);
+					if (i) {
+						g.lineTo(p[0], p[1]);
+					} else {
+						g.moveTo(p[0], p[1]);
+					}
+				});
+				g.globalAlpha = ln.o.opacity === undefined ? 1 : ln.o.opacity;
+				g.strokeStyle = ln.o.color || '#3388ff';
+				g.lineWidth = ln.o.weight || 3;
+				g.stroke();
+			});
+		});
+		g.globalAlpha = 1;
+		on.forEach(function (gr) {
+			gr.mks.forEach(function (mk) {
+				var p = m.pix(mk.ll);
+				g.beginPath();
+				g.arc(p[0], p[1], mk.o.radius || 10, 0, 2 * Math.PI);
+				g.globalAlpha = mk.o.fillOpacity === undefined ? 0.2 : mk.o.fillOpacity;
+				g.fillStyle = mk.o.fillColor || mk.o.color || '#3388ff';
+				g.fill();
+				g.globalAlpha = 1;
+				if (mk.o.weight !== 0) {
+					g.strokeStyle = mk.o.color || '#3388ff';
+					g.lineWidth = mk.o.weight || 3;
+					g.stroke();
+				}
+			});
+		});
+		g.font = 'bold 11px sans-serif';
+		g.lineWidth = 3;
+		g.strokeStyle = '#fff';
+		g.fillStyle = '#000';
+		on.forEach(function (gr) {
+			gr.mks.forEach(function (mk) {
+				if (mk.tip) {
+					var p = m.pix(mk.ll), x = p[0] + (mk.o.radius || 10) + 3;
+					g.strokeText(mk.tip, x, p[1] + 4);
+					g.fillText(mk.tip, x, p[1] + 4);
+				}
+			});
+		});
+
+		if (m.scale) {
+			var mpp = 2 * Math.PI * R * Math.cos(m.ctr[0] * Math.PI / 180) / (TS * Math.pow(2, m.z));
+			var d = Math.pow(10, Math.floor(Math.log(mpp * 100) / Math.LN10)), f = mpp * 100 / d;
+			d *= f >= 5 ? 5 : f >= 2 ? 2 : 1;
+			m.scale.style.width = Math.round(d / mpp) + 'px';
+			m.scale.textContent = d >= 1000 ? d / 1000 + ' km' : d + ' m';
+		}
+		if (m.popAt) {
+			var q = m.pix(m.popAt.ll);
+			m.pop.style.left = q[0] + 'px';
+			m.pop.style.top = (q[1] - (m.popAt.o.radius || 10) - 6) + 'px';
+		}
+	};
+
+	// tile image, loading it the first time it is asked for
+	Map.prototype.tile = function (z, x, y) {
+		var m = this, u = m.tiles.replace('{z}', z).replace('{x}', x).replace('{y}', y).replace('{s}', 'a').replace('{r}', '');
+		if (!m.imgs[u]) {
+			m.imgs[u] = new Image();
+			m.imgs[u].onload = function () { m.draw(); };
+			m.imgs[u].src = u;
+		}
+		return m.imgs[u];
+	};
+
+	// popup of the top stop under x, y, or close the open one
+	Map.prototype.click = function (x, y) {
+		var m = this, hit = null;
+		m.groups.forEach(function (gr) {
+			if (!gr.on) {
+				return;
+			}
+			gr.mks.forEach(function (mk) {
+				var p = m.pix(mk.ll);
+				if (mk.pop && Math.hypot(p[0] - x, p[1] - y) <= (mk.o.radius || 10) + 3) {
+					hit = mk;
+				}
+			});
+		});
+		m.popAt = hit;
+		m.pop.style.display = hit ? 'block' : 'none';
+		if (hit) {
+			m.pop.innerHTML = hit.pop;
+			var x0 = document.createElement('span');
+			x0.className = 'x';
+			x0.textContent = '×';
+			x0.onclick = function () { m.click(-1e9, -1e9); };
+			m.pop.appendChild(x0);
+		}
+		m.render();
+	};
+
+	// a map, or a group once it is on one
+	function redraw(t) {
+		var m = t.draw ? t : t.map;
+		if (m) {
+			m.draw();
+		}
+	}
+
+	function Group() {
+		this.lines = [];
+		this.mks = [];
+		this.on = true;
+		this.map = null;
+	}
+
+	Group.prototype.addTo = function (m) {
+		this.map = m;
+		m.groups.push(this);
+		m.draw();
+		return this;
+	};
+
+	function Line(pts, o) {
+		this.pts = pts;
+		this.o = o || {};
+	}
+
+	Line.prototype.addTo = function (t) {
+		(t.lines ? t : t.layer()).lines.push(this);
+		redraw(t);
+		return this;
+	};
+
+	function Marker(ll, o) {
+		this.ll = ll;
+		this.o = o || {};
+	}
+
+	Marker.prototype.addTo = function (t) {
+		(t.mks ? t : t.layer()).mks.push(this);
+		redraw(t);
+		return this;
+	};
+
+	Marker.prototype.bindPopup = function (h) {
+		this.pop = h;
+		return this;
+	};
+
+	Marker.prototype.bindTooltip = function (h) {
+		this.tip = plain(h);
+		return this;
+	};
+
+	// lines and markers added straight to the map go on a group of their own
+	Map.prototype.layer = function () {
+		if (!this.own) {
+			this.own = new Group().addTo(this);
+		}
+		return this.own;
+	};
+
+	function Tiles(url, o) {
+		this.url = url;
+		this.o = o || {};
+	}
+
+	Tiles.prototype.addTo = function (m) {
+		m.tiles = this.url;
+		if (this.o.maxZoom !== undefined) {
+			m.maxZ = this.o.maxZoom;
+		}
+		m.attr.innerHTML = this.o.attribution || '';
+		m.draw();
+		return this;
+	};
+
+	// a checkbox per overlay
+	function Layers(base, over) {
+		this.over = over || {};
+	}
+
+	Layers.prototype.addTo = function (m) {
+		var over = this.over;
+		Object.keys(over).forEach(function (nm) {
+			var lab = document.createElement('label'), cb = document.createElement('input');
+			cb.type = 'checkbox';
+			cb.checked = over[nm].on;
+			cb.onchange = function () {
+				over[nm].on = cb.checked;
+				if (m.popAt && !cb.checked) {
+					m.click(-1e9, -1e9);
+				}
+				m.draw();
+			};
+			lab.appendChild(cb);
+			lab.appendChild(document.createTextNode(' ' + nm));
+			m.ctl.appendChild(lab);
+		});
+		m.ctl.style.display = '';
+		return this;
+	};
+
+	function Scale() {}
+
+	Scale.prototype.addTo = function (m) {
+		m.scale = div('tss-scale', m.el);
+		m.draw();
+		return this;
+	};
+
+	return {
+		map: function (id) { return new Map(id); },
+		tileLayer: function (url, o) { return new Tiles(url, o); },
+		layerGroup: function () { return new Group(); },
+		polyline: function (pts, o) { return new Line(pts, o); },
+		circleMarker: function (ll, o) { return new Marker(ll, o); },
+		control: {
+			layers: function (base, over) { return new Layers(base, over); },
+			scale: function () { return new Scale(); }
+		}
+	};
+}());